@@ -0,0 +1,92 @@
+// tokenserver 启动一个AccessToken中控服务器
+//
+// 配置文件为json格式:
+//
+//	{
+//		"accounts": [{"app_id": "wx...", "app_secret": "..."}],
+//		"secret": "共享密钥"
+//	}
+//
+// 使用 -client-ca 时会要求客户端提供由该CA签发的证书(mTLS)
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/meikeland/go-wechat/tokenserver"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "监听地址")
+	configPath := flag.String("config", "tokenserver.json", "配置文件路径")
+	certFile := flag.String("tls-cert", "", "TLS证书文件")
+	keyFile := flag.String("tls-key", "", "TLS私钥文件")
+	clientCA := flag.String("client-ca", "", "校验客户端证书的CA文件，设置后启用mTLS")
+	flag.Parse()
+
+	data, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		log.Fatalf("读取配置文件失败 error: %s", err.Error())
+	}
+	config := &tokenserver.Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		log.Fatalf("解析配置文件失败 error: %s", err.Error())
+	}
+	if secret := os.Getenv("TOKENSERVER_SECRET"); len(secret) > 0 {
+		config.Secret = secret
+	}
+
+	httpServer := &http.Server{Addr: *addr}
+	if len(*clientCA) > 0 {
+		pem, err := ioutil.ReadFile(*clientCA)
+		if err != nil {
+			log.Fatalf("读取客户端CA失败 error: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatal("客户端CA文件中没有有效的证书")
+		}
+		httpServer.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+		config.RequireClientCert = true
+	}
+
+	server, err := tokenserver.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.Start()
+	httpServer.Handler = server
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		server.Stop()
+		httpServer.Close()
+	}()
+
+	log.Printf("中控服务器监听 %s", *addr)
+	if len(*certFile) > 0 {
+		err = httpServer.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		if config.RequireClientCert {
+			log.Fatal("启用mTLS时必须设置 -tls-cert 和 -tls-key")
+		}
+		err = httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
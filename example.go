@@ -16,12 +16,15 @@ var (
 )
 
 func main() {
-	config := &wechat.APIConfig{
-		AppID:                  "wxadbd9d3df031fabf",
-		AppSecret:              "3b4993c244c39759727c863de53baddb",
-		AccessTokenCachePolicy: wechat.CachePolicyAutonomy,
+	// 从环境变量 WECHAT_APP_ID、WECHAT_APP_SECRET、WECHAT_ACCESS_TOKEN_CACHE_POLICY 等读取配置
+	config, err := wechat.LoadConfigFromEnv("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wechatClient, err = wechat.NewClient(config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	wechatClient = wechat.New(config)
 
 	router := gin.Default()
 	router.LoadHTMLGlob("templates/*")
@@ -0,0 +1,383 @@
+// Package tokenserver 实现AccessToken中控服务器
+// 中控服务器统一维护一个或多个公众号的AccessToken与jsapi_ticket，
+// 其他使用 wechat.CachePolicyHTTP 策略的APIClient通过HTTP向中控服务器获取，
+// 避免多个进程各自刷新AccessToken而相互导致对方的AccessToken失效
+package tokenserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gotit/errors"
+	"github.com/meikeland/go-wechat/wechat"
+)
+
+const (
+	durationRefreshAhead = time.Minute * 5  // 在AccessToken过期前多久开始刷新
+	durationRetry        = time.Second * 10 // 刷新失败后的重试间隔
+	durationIdle         = time.Minute      // 没有需要刷新的公众号时的检查间隔
+)
+
+// Account 需要中控服务器维护AccessToken的公众号
+type Account struct {
+	AppID     string `json:"app_id"`     // 公众号AppID
+	AppSecret string `json:"app_secret"` // 公众号AppSecret
+}
+
+// Config 中控服务器的配置参数
+type Config struct {
+	Accounts          []Account `json:"accounts"`            // 需要维护的公众号
+	Secret            string    `json:"secret"`              // 共享密钥，客户端需在 X-Wechat-Token-Secret 头中携带，为空时不校验
+	RequireClientCert bool      `json:"require_client_cert"` // 是否要求客户端提供经过验证的TLS证书(mTLS)
+	Stable            bool      `json:"stable"`              // 是否使用稳定版接口 /cgi-bin/stable_token 获取AccessToken
+
+	Options []wechat.Option `json:"-"` // 创建各公众号APIClient时的可选配置，如代理、超时
+}
+
+// Ticket 中控服务器返回的jsapi_ticket
+type Ticket struct {
+	Ticket    string `json:"ticket"`     // jsapi_ticket
+	ExpiresIn int64  `json:"expires_in"` // 剩余有效秒数
+	ExpiresAt int64  `json:"expires_at"` // 过期时间的unix时间戳
+	Errcode   int    `json:"errcode"`    // 错误码，0为正常
+	Errmsg    string `json:"errmsg"`     // 错误信息
+}
+
+// Status 单个公众号的AccessToken和jsapi_ticket过期时间
+type Status struct {
+	AppID           string `json:"app_id"`
+	TokenExpiresAt  int64  `json:"token_expires_at"`
+	TicketExpiresAt int64  `json:"ticket_expires_at"`
+}
+
+// Server 中控服务器，实现了http.Handler，提供以下接口:
+//
+//	GET /token?appid=APPID              返回 wechat.CentralAccessToken
+//	GET /token?appid=APPID&force_refresh=1  强制刷新后返回 wechat.CentralAccessToken
+//	                                    请求带有 X-Wechat-Invalid-Token 头且与当前AccessToken相同时，也会强制刷新
+//	GET /ticket?appid=APPID&type=jsapi  返回 Ticket
+//	GET /status                         返回所有公众号的 Status
+type Server struct {
+	secret            string
+	requireClientCert bool
+	accounts          map[string]*account
+	mux               *http.ServeMux
+	stop              chan struct{}
+	done              chan struct{}
+	once              sync.Once
+}
+
+// account 中控服务器维护的单个公众号
+type account struct {
+	client *wechat.APIClient
+	stable bool // 是否使用稳定版接口获取AccessToken
+
+	refreshMu      sync.Mutex // 串行化向微信获取AccessToken和jsapi_ticket的请求，避免并发获取时较旧的AccessToken覆盖较新的
+	mu             sync.RWMutex
+	token          string
+	tokenFetchedAt time.Time // 开始获取当前AccessToken的时间
+	tokenExpireAt  time.Time
+	ticket         string
+	ticketExpireAt time.Time
+	nextAttempt    time.Time                  // 下一次尝试刷新的时间
+	forceLimiter   wechat.ForceRefreshLimiter // 客户端要求强制刷新的频率限制，所有客户端共用
+}
+
+// New 创建一个中控服务器，需要调用Start开始维护AccessToken
+func New(config *Config) (*Server, error) {
+	if len(config.Accounts) == 0 {
+		return nil, errors.New("中控服务器至少需要配置一个公众号")
+	}
+	s := &Server{
+		secret:            config.Secret,
+		requireClientCert: config.RequireClientCert,
+		accounts:          make(map[string]*account),
+		mux:               http.NewServeMux(),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	for _, a := range config.Accounts {
+		if len(a.AppID) == 0 || len(a.AppSecret) == 0 {
+			return nil, errors.New("公众号的AppID和AppSecret不能为空")
+		}
+		if _, ok := s.accounts[a.AppID]; ok {
+			return nil, errors.Errorf("公众号 %s 重复配置", a.AppID)
+		}
+		client, err := wechat.NewClient(&wechat.APIConfig{
+			AppID:                  a.AppID,
+			AppSecret:              a.AppSecret,
+			AccessTokenCachePolicy: wechat.CachePolicyNone,
+		}, config.Options...)
+		if err != nil {
+			return nil, err
+		}
+		s.accounts[a.AppID] = &account{
+			client: client,
+			stable: config.Stable,
+		}
+	}
+
+	s.mux.HandleFunc("/token", s.handleToken)
+	s.mux.HandleFunc("/ticket", s.handleTicket)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	return s, nil
+}
+
+// Start 启动刷新循环，所有公众号的AccessToken都由这一个循环维护
+func (s *Server) Start() {
+	go s.loop()
+}
+
+// Stop 停止刷新循环
+func (s *Server) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// ServeHTTP 校验客户端身份后分发请求
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSON(w, http.StatusMethodNotAllowed, &wechat.CentralAccessToken{Errcode: -1, Errmsg: "method not allowed"})
+		return
+	}
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, &wechat.CentralAccessToken{Errcode: -1, Errmsg: "unauthorized"})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized 校验共享密钥与客户端证书
+func (s *Server) authorized(r *http.Request) bool {
+	if s.requireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return false
+	}
+	if len(s.secret) > 0 {
+		secret := r.Header.Get(wechat.CentralTokenSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(s.secret)) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	a, ok := s.accounts[query.Get("appid")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, &wechat.CentralAccessToken{Errcode: 40013, Errmsg: "invalid appid"})
+		return
+	}
+	invalid := r.Header.Get(wechat.CentralTokenInvalidHeader)
+	if query.Get("force_refresh") == "1" || len(invalid) > 0 {
+		if err := a.forceRefresh(invalid); err != nil {
+			log.Printf("强制刷新公众号 %s 的AccessToken失败 error: %s", query.Get("appid"), err.Error())
+			if err == wechat.ErrForceRefreshLimited {
+				writeJSON(w, http.StatusTooManyRequests, &wechat.CentralAccessToken{Errcode: 45011, Errmsg: err.Error()})
+			} else {
+				writeJSON(w, http.StatusBadGateway, &wechat.CentralAccessToken{Errcode: -1, Errmsg: err.Error()})
+			}
+			return
+		}
+	}
+	a.mu.RLock()
+	token, expireAt := a.token, a.tokenExpireAt
+	a.mu.RUnlock()
+
+	now := time.Now()
+	if len(token) == 0 || !now.Before(expireAt) {
+		writeJSON(w, http.StatusServiceUnavailable, &wechat.CentralAccessToken{Errcode: -1, Errmsg: "access_token not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &wechat.CentralAccessToken{
+		AccessToken: token,
+		ExpiresIn:   int64(expireAt.Sub(now) / time.Second),
+		ExpiresAt:   expireAt.Unix(),
+	})
+}
+
+func (s *Server) handleTicket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if t := query.Get("type"); len(t) > 0 && t != "jsapi" {
+		writeJSON(w, http.StatusBadRequest, &Ticket{Errcode: 40097, Errmsg: "invalid ticket type"})
+		return
+	}
+	a, ok := s.accounts[query.Get("appid")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, &Ticket{Errcode: 40013, Errmsg: "invalid appid"})
+		return
+	}
+	a.mu.RLock()
+	ticket, expireAt := a.ticket, a.ticketExpireAt
+	a.mu.RUnlock()
+
+	now := time.Now()
+	if len(ticket) == 0 || !now.Before(expireAt) {
+		writeJSON(w, http.StatusServiceUnavailable, &Ticket{Errcode: -1, Errmsg: "jsapi_ticket not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &Ticket{
+		Ticket:    ticket,
+		ExpiresIn: int64(expireAt.Sub(now) / time.Second),
+		ExpiresAt: expireAt.Unix(),
+	})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]Status, 0, len(s.accounts))
+	for appID, a := range s.accounts {
+		a.mu.RLock()
+		statuses = append(statuses, Status{
+			AppID:           appID,
+			TokenExpiresAt:  a.tokenExpireAt.Unix(),
+			TicketExpiresAt: a.ticketExpireAt.Unix(),
+		})
+		a.mu.RUnlock()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// loop 刷新循环，每次醒来刷新所有临近过期的公众号，然后休眠到下一个公众号需要刷新的时间
+func (s *Server) loop() {
+	defer close(s.done)
+	for {
+		next := s.refreshDue(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// refreshDue 刷新所有需要刷新的公众号，返回下一次需要醒来的时间
+func (s *Server) refreshDue(now time.Time) time.Time {
+	next := now.Add(durationIdle)
+	for appID, a := range s.accounts {
+		if !now.Before(a.due()) {
+			if err := a.refresh(now); err != nil {
+				log.Printf("刷新公众号 %s 失败 error: %s", appID, err.Error())
+			}
+		}
+		if due := a.due(); due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// due 返回该公众号下一次需要刷新的时间，AccessToken和jsapi_ticket中先临近过期的一个决定刷新时间
+func (a *account) due() time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	due := a.tokenExpireAt
+	if a.ticketExpireAt.Before(due) {
+		due = a.ticketExpireAt
+	}
+	due = due.Add(-durationRefreshAhead)
+	if a.nextAttempt.After(due) {
+		due = a.nextAttempt
+	}
+	return due
+}
+
+// refresh 从微信服务器获取临近过期的AccessToken和jsapi_ticket
+func (a *account) refresh(now time.Time) error {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	a.mu.Lock()
+	a.nextAttempt = now.Add(durationRetry)
+	token := a.token
+	tokenDue := !now.Before(a.tokenExpireAt.Add(-durationRefreshAhead))
+	ticketDue := !now.Before(a.ticketExpireAt.Add(-durationRefreshAhead))
+	a.mu.Unlock()
+
+	if tokenDue {
+		fetchedAt := time.Now()
+		accessToken, expiresIn, err := a.fetchToken(false)
+		if err != nil {
+			return err
+		}
+		if a.setToken(accessToken, expiresIn, fetchedAt) {
+			token = accessToken
+		}
+	}
+
+	if ticketDue {
+		ticket, expiresIn, err := a.client.AccessToken.GetJsapiTicket(token)
+		if err != nil {
+			return errors.Errorf("获取jsapi_ticket失败 %s", err.Error())
+		}
+		a.mu.Lock()
+		a.ticket = ticket
+		a.ticketExpireAt = now.Add(time.Duration(expiresIn) * time.Second)
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// forceRefresh 应客户端要求强制刷新AccessToken，两次强制刷新需间隔30秒且每天不超过20次，超出时返回wechat.ErrForceRefreshLimited
+// invalid不为空时，只在当前AccessToken就是这个已失效的AccessToken时刷新，已经刷新过则直接返回
+func (a *account) forceRefresh(invalid string) error {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	if len(invalid) > 0 {
+		a.mu.RLock()
+		current := a.token
+		a.mu.RUnlock()
+		if current != invalid {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	if !a.forceLimiter.Allow(now) {
+		return wechat.ErrForceRefreshLimited
+	}
+
+	token, expiresIn, err := a.fetchToken(true)
+	if err != nil {
+		return err
+	}
+	a.setToken(token, expiresIn, now)
+	return nil
+}
+
+// setToken 保存在fetchedAt开始获取的AccessToken，比当前AccessToken获取得早时丢弃，返回是否保存
+// 非稳定版接口每次获取都会使之前的AccessToken失效，较早获取的结果已经无效
+func (a *account) setToken(token string, expiresIn int64, fetchedAt time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if fetchedAt.Before(a.tokenFetchedAt) {
+		return false
+	}
+	a.token = token
+	a.tokenFetchedAt = fetchedAt
+	a.tokenExpireAt = fetchedAt.Add(time.Duration(expiresIn) * time.Second)
+	return true
+}
+
+// fetchToken 从微信服务器获取AccessToken，使用稳定版接口时force表示是否强制刷新
+func (a *account) fetchToken(force bool) (string, int64, error) {
+	if a.stable {
+		return a.client.AccessToken.GetStable(force)
+	}
+	return a.client.AccessToken.Get()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
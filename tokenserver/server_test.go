@@ -0,0 +1,170 @@
+package tokenserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meikeland/go-wechat/wechat"
+)
+
+const testAppID = "wx1234567890abcdef"
+
+// newTestServer 创建请求模拟微信接口的中控服务器，每次获取AccessToken依次返回T1、T2……
+// 返回的calls记录获取AccessToken的次数
+func newTestServer(t *testing.T, secret string) (*Server, *int32) {
+	t.Helper()
+	calls := new(int32)
+	wx := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/token":
+			n := atomic.AddInt32(calls, 1)
+			time.Sleep(time.Millisecond * 10)
+			fmt.Fprintf(rw, `{"access_token":"T%d","expires_in":7200}`, n)
+		case "/cgi-bin/ticket/getticket":
+			fmt.Fprint(rw, `{"errcode":0,"errmsg":"ok","ticket":"TICKET","expires_in":7200}`)
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	t.Cleanup(wx.Close)
+
+	s, err := New(&Config{
+		Accounts: []Account{{AppID: testAppID, AppSecret: "0123456789abcdef0123456789abcdef"}},
+		Secret:   secret,
+		Options:  []wechat.Option{wechat.WithBaseURL(wx.URL)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.refreshDue(time.Now())
+	return s, calls
+}
+
+// getToken 请求中控服务器的/token，header为附加的请求头
+func getToken(s *Server, query string, header http.Header) (int, *wechat.CentralAccessToken) {
+	r := httptest.NewRequest("GET", "/token?appid="+testAppID+query, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	token := &wechat.CentralAccessToken{}
+	json.Unmarshal(rec.Body.Bytes(), token)
+	return rec.Code, token
+}
+
+// invalidHeader 告知中控服务器token已失效的请求头
+func invalidHeader(token string) http.Header {
+	return http.Header{wechat.CentralTokenInvalidHeader: {token}}
+}
+
+// TestHandleTokenForceRefresh force_refresh=1时强制刷新，受强制刷新的频率限制
+func TestHandleTokenForceRefresh(t *testing.T) {
+	s, calls := newTestServer(t, "")
+	if code, token := getToken(s, "", nil); code != http.StatusOK || token.AccessToken != "T1" || token.ExpiresIn <= 0 {
+		t.Fatalf("应返回T1，实际返回 %d %+v", code, token)
+	}
+	if code, token := getToken(s, "&force_refresh=1", nil); code != http.StatusOK || token.AccessToken != "T2" {
+		t.Fatalf("强制刷新后应返回T2，实际返回 %d %+v", code, token)
+	}
+	if code, token := getToken(s, "&force_refresh=1", nil); code != http.StatusTooManyRequests || token.Errcode != 45011 {
+		t.Fatalf("30秒内再次强制刷新应返回429，实际返回 %d %+v", code, token)
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Fatalf("获取了%d次AccessToken，应为2次", n)
+	}
+}
+
+// TestHandleTokenInvalidHeader 客户端告知的失效token就是当前AccessToken时强制刷新，已经刷新过则直接返回新的AccessToken
+func TestHandleTokenInvalidHeader(t *testing.T) {
+	s, calls := newTestServer(t, "")
+	if code, token := getToken(s, "", invalidHeader("T1")); code != http.StatusOK || token.AccessToken != "T2" {
+		t.Fatalf("T1失效后应返回T2，实际返回 %d %+v", code, token)
+	}
+	// 其他客户端随后也报告T1失效，不再刷新，也不受强制刷新的频率限制
+	for i := 0; i < 3; i++ {
+		if code, token := getToken(s, "", invalidHeader("T1")); code != http.StatusOK || token.AccessToken != "T2" {
+			t.Fatalf("应直接返回T2，实际返回 %d %+v", code, token)
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Fatalf("获取了%d次AccessToken，应为2次", n)
+	}
+	if code, token := getToken(s, "", invalidHeader("T2")); code != http.StatusTooManyRequests || token.Errcode != 45011 {
+		t.Fatalf("30秒内再次强制刷新应返回429，实际返回 %d %+v", code, token)
+	}
+}
+
+// TestServeHTTPSecret 配置了共享密钥时，没有携带或携带错误密钥的请求返回401
+func TestServeHTTPSecret(t *testing.T) {
+	s, _ := newTestServer(t, "s3cret")
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"没有密钥", nil, http.StatusUnauthorized},
+		{"错误的密钥", http.Header{wechat.CentralTokenSecretHeader: {"wrong"}}, http.StatusUnauthorized},
+		{"正确的密钥", http.Header{wechat.CentralTokenSecretHeader: {"s3cret"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, token := getToken(s, "", tt.header); code != tt.want {
+			t.Errorf("%s: 应返回%d，实际返回 %d %+v", tt.name, tt.want, code, token)
+		}
+	}
+
+	// 密钥错误时不会因为失效token强制刷新
+	if code, _ := getToken(s, "&force_refresh=1", invalidHeader("T1")); code != http.StatusUnauthorized {
+		t.Fatalf("应返回401，实际返回 %d", code)
+	}
+	if _, token := getToken(s, "", http.Header{wechat.CentralTokenSecretHeader: {"s3cret"}}); token.AccessToken != "T1" {
+		t.Fatalf("未授权的请求不应刷新AccessToken，实际返回 %+v", token)
+	}
+}
+
+// TestSetTokenStale 较早开始获取的AccessToken比当前AccessToken晚返回时被丢弃
+func TestSetTokenStale(t *testing.T) {
+	a := &account{}
+	now := time.Now()
+	if !a.setToken("new", 7200, now) {
+		t.Fatal("应保存new")
+	}
+	if a.setToken("old", 7200, now.Add(-time.Second)) {
+		t.Fatal("较早获取的old应被丢弃")
+	}
+	if a.token != "new" || !a.tokenExpireAt.Equal(now.Add(time.Hour*2)) {
+		t.Fatalf("当前AccessToken为 %s %v，应为new", a.token, a.tokenExpireAt)
+	}
+}
+
+// TestRefreshConcurrentWithForce 定时刷新与强制刷新同时进行时，最终返回最后获取的AccessToken
+func TestRefreshConcurrentWithForce(t *testing.T) {
+	s, calls := newTestServer(t, "")
+	a := s.accounts[testAppID]
+	a.mu.Lock()
+	a.tokenExpireAt = time.Now() // 使定时刷新认为AccessToken需要刷新
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.refresh(time.Now())
+	}()
+	go func() {
+		defer wg.Done()
+		a.forceRefresh("")
+	}()
+	wg.Wait()
+
+	want := fmt.Sprintf("T%d", atomic.LoadInt32(calls))
+	if _, token := getToken(s, "", nil); token.AccessToken != want {
+		t.Fatalf("应返回最后获取的 %s，实际返回 %+v", want, token)
+	}
+}
@@ -1,9 +1,9 @@
 package wechat
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 )
 
@@ -11,9 +11,10 @@ import (
 type AccessTokenService service
 
 const (
-	durationRefreshAccessToken = time.Second * 30
-	urlGetAccessToken          = "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
-	urlVerifyAccessToken       = "https://api.weixin.qq.com/cgi-bin/getcallbackip?access_token=%s"
+	durationTokenExpireAhead = time.Second * 60 // AccessToken在到期前多久视为失效，提前刷新
+	urlGetAccessToken        = "cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
+	urlGetStableAccessToken  = "cgi-bin/stable_token"
+	urlVerifyAccessToken     = "cgi-bin/getcallbackip?access_token=%s"
 )
 
 // Verify 验证当前的access_token是否有效，由于微信并没有提供一个验证有效性的接口
@@ -21,57 +22,109 @@ const (
 // 这个接口是获取微信服务器IP地址，在微信公众号文档的"开始开发"章节
 // 这个接口可能会随着微信的功能改进而取消掉，但至少在目前是可用的
 func (s *AccessTokenService) Verify(accessToken string) bool {
+	return s.VerifyContext(context.Background(), accessToken)
+}
+
+// VerifyContext 与Verify相同，通过ctx控制请求的超时与取消
+func (s *AccessTokenService) VerifyContext(ctx context.Context, accessToken string) bool {
 	url := fmt.Sprintf(urlVerifyAccessToken, accessToken)
 
 	req, err := s.wechat.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Printf("获取AccessToken请求异常 error: %s", err.Error())
+		s.wechat.logger.Error("获取AccessToken请求异常", "error", err)
 		return false
 	}
 
 	result := &struct {
-		Errcode int    `json:"errcode"`
-		Errmsg  string `json:"errmsg"`
+		IPList []string `json:"ip_list"`
 	}{}
-	_, err = s.wechat.Do(nil, req, result)
-	if err != nil {
-		return false
-	}
-	if result.Errcode != 0 {
-		return false
-	}
-
-	return true
+	_, err = s.wechat.Do(withoutTokenCheck(ctx), req, result)
+	return err == nil
 }
 
 // Get 从微信服务器获取AccessToken
 func (s *AccessTokenService) Get() (string, int64, error) {
+	return s.GetContext(context.Background())
+}
+
+// GetContext 与Get相同，通过ctx控制请求的超时与取消
+func (s *AccessTokenService) GetContext(ctx context.Context) (string, int64, error) {
 	url := fmt.Sprintf(urlGetAccessToken, s.wechat.AppID, s.wechat.AppSecret)
 	req, err := s.wechat.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Printf("获取AccessToken请求异常 error: %s", err.Error())
+		s.wechat.logger.Error("获取AccessToken请求异常", "error", err)
 		return "", 0, errors.New("无法创建获取AccessToken的请求")
 	}
 
 	token := &struct {
-		AccessToken  string `json:"access_token"`
-		ExpiresIn    int64  `json:"expires_in"`
-		Errorcode    int    `json:"errcode"`
-		ErrorMessage string `json:"errmsg"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
 	}{}
-	_, err = s.wechat.Do(nil, req, token)
+	_, err = s.wechat.Do(ctx, req, token)
 	if err != nil {
-		log.Printf("获取AccessToken请求异常 error: %s", err.Error())
-		return "", 0, errors.New("无法获取AccessToken的请求")
+		s.wechat.logger.Error("获取AccessToken请求异常", "error", err)
+		return "", 0, err
 	}
 
-	if token.Errorcode != 0 {
-		log.Print(token.ErrorMessage)
-		return "", 0, errors.New(token.ErrorMessage)
+	if len(token.AccessToken) == 0 {
+		s.wechat.logger.Warn("获取AccessToken失败，未知原因")
 	}
+	return token.AccessToken, token.ExpiresIn, nil
+}
 
-	if len(token.AccessToken) == 0 {
-		log.Println("获取AccessToken失败，未知原因")
+// GetStable 从微信服务器获取稳定版AccessToken
+// 普通模式(forceRefresh为false)下，有效期内重复获取返回同一个AccessToken，不会使其他持有者的AccessToken失效
+// 强制刷新模式(forceRefresh为true)会使之前的AccessToken失效，微信限制每天20次且需间隔30秒
+func (s *AccessTokenService) GetStable(forceRefresh bool) (string, int64, error) {
+	return s.GetStableContext(context.Background(), forceRefresh)
+}
+
+// GetStableContext 与GetStable相同，通过ctx控制请求的超时与取消
+func (s *AccessTokenService) GetStableContext(ctx context.Context, forceRefresh bool) (string, int64, error) {
+	param := map[string]interface{}{
+		"grant_type":    "client_credential",
+		"appid":         s.wechat.AppID,
+		"secret":        s.wechat.AppSecret,
+		"force_refresh": forceRefresh,
+	}
+	req, err := s.wechat.NewRequest("POST", urlGetStableAccessToken, param)
+	if err != nil {
+		s.wechat.logger.Error("获取稳定版AccessToken请求异常", "error", err)
+		return "", 0, errors.New("无法创建获取稳定版AccessToken的请求")
+	}
+
+	token := &struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	_, err = s.wechat.Do(ctx, req, token)
+	if err != nil {
+		s.wechat.logger.Error("获取稳定版AccessToken请求异常", "error", err)
+		return "", 0, err
 	}
 	return token.AccessToken, token.ExpiresIn, nil
 }
+
+// GetJsapiTicket 用AccessToken从微信服务器获取jsapi_ticket，返回ticket及其有效秒数
+func (s *AccessTokenService) GetJsapiTicket(accessToken string) (string, int64, error) {
+	return s.GetJsapiTicketContext(context.Background(), accessToken)
+}
+
+// GetJsapiTicketContext 与GetJsapiTicket相同，通过ctx控制请求的超时与取消
+func (s *AccessTokenService) GetJsapiTicketContext(ctx context.Context, accessToken string) (string, int64, error) {
+	url := fmt.Sprintf(urlJsapiTicket, accessToken)
+	req, err := s.wechat.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	ticket := &struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int64  `json:"expires_in"`
+	}{}
+	_, err = s.wechat.Do(ctx, req, ticket)
+	if err != nil {
+		return "", 0, err
+	}
+	return ticket.Ticket, ticket.ExpiresIn, nil
+}
@@ -0,0 +1,92 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+const (
+	// CentralTokenSecretHeader 请求中控服务器时携带共享密钥的HTTP头
+	CentralTokenSecretHeader = "X-Wechat-Token-Secret"
+	// CentralTokenInvalidHeader 请求中控服务器时携带已被微信判定失效的AccessToken的HTTP头
+	CentralTokenInvalidHeader = "X-Wechat-Invalid-Token"
+)
+
+/*
+CentralAccessToken 中控服务器返回的AccessToken
+当AccessToken缓存策略为http时，APIClient会以GET方式请求 AccessTokenCacheAddress?appid={AppID}
+强制刷新时请求 AccessTokenCacheAddress?appid={AppID}&force_refresh=1
+若设置了AccessTokenCacheSecret，请求会在 X-Wechat-Token-Secret 头中携带该密钥
+微信返回AccessToken失效的错误码时，请求会在 X-Wechat-Invalid-Token 头中携带失效的AccessToken，
+中控服务器的AccessToken与之相同时需刷新后返回新的AccessToken
+中控服务器需以json格式返回:
+
+	成功 {"access_token":"ACCESS_TOKEN","expires_in":7000}，expires_in为该AccessToken剩余的有效秒数
+	失败 {"errcode":40013,"errmsg":"invalid appid"}
+*/
+type CentralAccessToken struct {
+	AccessToken string `json:"access_token"` // 公众号AccessToken
+	ExpiresIn   int64  `json:"expires_in"`   // 剩余有效秒数
+	ExpiresAt   int64  `json:"expires_at"`   // 过期时间的unix时间戳，可选
+	Errcode     int    `json:"errcode"`      // 错误码，0为正常
+	Errmsg      string `json:"errmsg"`       // 错误信息
+}
+
+// TokenServerError 无法从中控服务器获取AccessToken时返回的错误
+type TokenServerError struct {
+	Address string // 中控服务器地址
+	Errcode int    // 中控服务器返回的错误码
+	Errmsg  string // 中控服务器返回的错误信息
+	Err     error  // 请求中控服务器时的底层错误
+}
+
+func (e *TokenServerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("无法从中控服务器 %s 获取AccessToken: %v", e.Address, e.Err)
+	}
+	return fmt.Sprintf("中控服务器 %s 返回错误 %d %s", e.Address, e.Errcode, e.Errmsg)
+}
+
+// Unwrap 返回底层错误
+func (e *TokenServerError) Unwrap() error {
+	return e.Err
+}
+
+// fetchCentralAccessToken 向中控服务器请求AccessToken，force为true时要求中控服务器强制刷新
+// invalid不为空时告知中控服务器这个AccessToken已失效
+func (w *APIClient) fetchCentralAccessToken(ctx context.Context, force bool, invalid string) (*CentralAccessToken, error) {
+	u, err := url.Parse(w.accessTokenCacheAddress)
+	if err != nil {
+		return nil, &TokenServerError{Address: w.accessTokenCacheAddress, Err: err}
+	}
+	query := u.Query()
+	query.Set("appid", w.AppID)
+	if force {
+		query.Set("force_refresh", "1")
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := w.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, &TokenServerError{Address: w.accessTokenCacheAddress, Err: err}
+	}
+	if len(w.accessTokenCacheSecret) > 0 {
+		req.Header.Set(CentralTokenSecretHeader, w.accessTokenCacheSecret)
+	}
+	if len(invalid) > 0 {
+		req.Header.Set(CentralTokenInvalidHeader, invalid)
+	}
+	token := &CentralAccessToken{}
+	_, err = w.Do(ctx, req, token)
+	if apiErr, ok := AsAPIError(err); ok {
+		return nil, &TokenServerError{Address: w.accessTokenCacheAddress, Errcode: apiErr.Code, Errmsg: apiErr.Msg}
+	}
+	if err != nil {
+		return nil, &TokenServerError{Address: w.accessTokenCacheAddress, Err: err}
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, &TokenServerError{Address: w.accessTokenCacheAddress, Errcode: token.Errcode, Errmsg: token.Errmsg}
+	}
+	return token, nil
+}
@@ -0,0 +1,242 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitState 熔断器的状态
+type CircuitState int
+
+// 熔断器的状态
+const (
+	CircuitClosed   CircuitState = iota // 正常请求
+	CircuitOpen                         // 接口不可用，直接返回*CircuitOpenError
+	CircuitHalfOpen                     // 允许少量试探请求，成功后恢复正常，失败后重新熔断
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerConfig 按接口熔断的配置
+// 网络错误、5xx及系统繁忙(-1)视为失败，其他errcode是业务错误，视为接口可用
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // 连续失败多少次后熔断，不大于0时关闭熔断
+	OpenTimeout      time.Duration // 熔断多久后进入半开状态
+	HalfOpenMaxCalls int           // 半开状态下同时允许的试探请求数
+}
+
+// DefaultCircuitBreakerConfig 默认的熔断配置，连续失败5次后熔断30秒
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      time.Second * 30,
+		HalfOpenMaxCalls: 1,
+	}
+}
+
+// validate 校验熔断配置
+func (c CircuitBreakerConfig) validate() error {
+	if c.FailureThreshold > 0 {
+		if c.OpenTimeout <= 0 {
+			return errors.New("熔断时间必须大于0")
+		}
+		if c.HalfOpenMaxCalls < 1 {
+			return errors.New("半开状态的试探请求数不能小于1")
+		}
+	}
+	return nil
+}
+
+// CircuitOpenError 接口已熔断，请求没有发送
+type CircuitOpenError struct {
+	Endpoint   string        // 接口路径
+	RetryAfter time.Duration // 多久后进入半开状态，半开状态下试探请求数已满时为0
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("微信接口 %s 已熔断，%s 后重试", e.Endpoint, e.RetryAfter)
+}
+
+// IsCircuitOpen 判断err是否表示接口已熔断
+func IsCircuitOpen(err error) bool {
+	var open *CircuitOpenError
+	return errors.As(err, &open)
+}
+
+// circuitBreaker 按接口路径熔断
+type circuitBreaker struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit 一个接口的熔断状态
+type circuit struct {
+	state    CircuitState
+	failures int       // 连续失败的次数
+	openedAt time.Time // 熔断的时间
+	trials   int       // 半开状态下正在进行的试探请求数
+	opens    int       // 熔断的次数，用于区分试探请求属于哪一次半开
+}
+
+// admission allow放行一个请求时的状态，记录结果时用于判断该请求是否为当前半开状态的试探请求
+type admission struct {
+	trial bool // 是否为半开状态下的试探请求
+	opens int  // 放行时的熔断次数
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config, circuits: map[string]*circuit{}}
+}
+
+// get 返回接口的熔断状态，熔断时间已过时转为半开，调用前需持有锁
+func (b *circuitBreaker) get(endpoint string, now time.Time) *circuit {
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		c = &circuit{}
+		b.circuits[endpoint] = c
+	}
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= b.config.OpenTimeout {
+		c.state = CircuitHalfOpen
+		c.trials = 0
+	}
+	return c
+}
+
+// allow 判断能否向接口发送请求，不能时返回*CircuitOpenError
+func (b *circuitBreaker) allow(endpoint string, now time.Time) (admission, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint, now)
+	switch c.state {
+	case CircuitOpen:
+		return admission{}, &CircuitOpenError{Endpoint: endpoint, RetryAfter: c.openedAt.Add(b.config.OpenTimeout).Sub(now)}
+	case CircuitHalfOpen:
+		if c.trials >= b.config.HalfOpenMaxCalls {
+			return admission{}, &CircuitOpenError{Endpoint: endpoint}
+		}
+		c.trials++
+		return admission{trial: true, opens: c.opens}, nil
+	}
+	return admission{opens: c.opens}, nil
+}
+
+// isTrial 请求是否为当前半开状态的试探请求，调用前需持有锁
+func (c *circuit) isTrial(a admission) bool {
+	return c.state == CircuitHalfOpen && a.trial && a.opens == c.opens
+}
+
+// record 记录允许发送的请求的结果
+// 熔断期间完成的请求是在熔断前放行的，其结果不改变状态；半开状态下只有试探请求的结果可以关闭或重新打开熔断
+func (b *circuitBreaker) record(endpoint string, a admission, failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint, now)
+	switch c.state {
+	case CircuitOpen:
+		return
+	case CircuitHalfOpen:
+		if !c.isTrial(a) {
+			return
+		}
+		c.trials--
+		if failed {
+			c.open(now)
+		} else {
+			c.state = CircuitClosed
+			c.failures = 0
+		}
+		return
+	}
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= b.config.FailureThreshold {
+		c.open(now)
+	}
+}
+
+// open 打开熔断，调用前需持有锁
+func (c *circuit) open(now time.Time) {
+	c.state = CircuitOpen
+	c.openedAt = now
+	c.opens++
+}
+
+// release 请求的结果无法判断接口是否可用，如ctx被取消，只归还半开状态的试探名额
+func (b *circuitBreaker) release(endpoint string, a admission, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint, now)
+	if c.isTrial(a) && c.trials > 0 {
+		c.trials--
+	}
+}
+
+// states 返回所有请求过的接口的熔断状态
+func (b *circuitBreaker) states(now time.Time) map[string]CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	states := make(map[string]CircuitState, len(b.circuits))
+	for endpoint := range b.circuits {
+		states[endpoint] = b.get(endpoint, now).state
+	}
+	return states
+}
+
+// CircuitStates 返回所有请求过的接口的熔断状态，可用于健康检查，未开启熔断时返回nil
+func (w *APIClient) CircuitStates() map[string]CircuitState {
+	if w.breaker == nil {
+		return nil
+	}
+	return w.breaker.states(time.Now())
+}
+
+// CircuitStateOf 返回接口的熔断状态，endpoint为接口路径，如 /card/get
+func (w *APIClient) CircuitStateOf(endpoint string) CircuitState {
+	if w.breaker == nil {
+		return CircuitClosed
+	}
+	return w.breaker.states(time.Now())[w.BaseURL.Path+strings.TrimLeft(endpoint, "/")]
+}
+
+// breakerInterceptor 接口熔断时直接返回*CircuitOpenError，否则发送请求并记录结果
+func (w *APIClient) breakerInterceptor(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request, info *CallInfo) error {
+		if w.breaker == nil {
+			return next(ctx, req, info)
+		}
+		a, err := w.breaker.allow(info.Endpoint, time.Now())
+		if err != nil {
+			return err
+		}
+
+		err = next(ctx, req, info)
+		switch {
+		case ctx.Err() != nil || (info.StatusCode == 0 && isLocalRejection(err)):
+			w.breaker.release(info.Endpoint, a, time.Now())
+		case err != nil && info.StatusCode == 0, info.StatusCode >= 500, info.Errcode == ErrcodeSystemBusy:
+			w.breaker.record(info.Endpoint, a, true, time.Now())
+		default:
+			w.breaker.record(info.Endpoint, a, false, time.Now())
+		}
+		return err
+	}
+}
@@ -0,0 +1,151 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testBreakerConfig = CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: time.Second, HalfOpenMaxCalls: 1}
+
+// assertCircuit 断言接口的熔断状态
+func assertCircuit(t *testing.T, b *circuitBreaker, endpoint string, now time.Time, want CircuitState) {
+	t.Helper()
+	if got := b.states(now)[endpoint]; got != want {
+		t.Fatalf("熔断状态为 %s，应为 %s", got, want)
+	}
+}
+
+// TestCircuitBreakerCycle 连续失败后熔断，熔断时间过后半开，试探失败重新熔断，试探成功恢复正常
+func TestCircuitBreakerCycle(t *testing.T) {
+	b := newCircuitBreaker(testBreakerConfig)
+	now := time.Now()
+	call := func(failed bool) {
+		t.Helper()
+		a, err := b.allow("/card/get", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.record("/card/get", a, failed, now)
+	}
+
+	// 失败次数在成功后清零
+	call(true)
+	call(false)
+	call(true)
+	assertCircuit(t, b, "/card/get", now, CircuitClosed)
+
+	call(true)
+	assertCircuit(t, b, "/card/get", now, CircuitOpen)
+	_, err := b.allow("/card/get", now.Add(time.Millisecond*400))
+	if open, ok := err.(*CircuitOpenError); !ok || open.RetryAfter != time.Millisecond*600 {
+		t.Fatalf("熔断时应返回RetryAfter为600ms的*CircuitOpenError，实际返回 %v", err)
+	}
+
+	// 半开状态下只放行HalfOpenMaxCalls个试探请求，试探失败重新熔断
+	now = now.Add(time.Second)
+	assertCircuit(t, b, "/card/get", now, CircuitHalfOpen)
+	trial, err := b.allow("/card/get", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.allow("/card/get", now); !IsCircuitOpen(err) {
+		t.Fatalf("试探请求数已满时应返回*CircuitOpenError，实际返回 %v", err)
+	}
+	b.record("/card/get", trial, true, now)
+	assertCircuit(t, b, "/card/get", now, CircuitOpen)
+
+	// 试探成功恢复正常
+	now = now.Add(time.Second)
+	call(false)
+	assertCircuit(t, b, "/card/get", now, CircuitClosed)
+
+	// 其他接口不受影响
+	assertCircuit(t, b, "/card/batchget", now, CircuitClosed)
+}
+
+// TestCircuitBreakerLateResult 熔断前放行的请求在熔断期间或半开状态下才完成，其结果不改变状态
+func TestCircuitBreakerLateResult(t *testing.T) {
+	b := newCircuitBreaker(testBreakerConfig)
+	now := time.Now()
+	slow, err := b.allow("/card/get", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < testBreakerConfig.FailureThreshold; i++ {
+		a, err := b.allow("/card/get", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.record("/card/get", a, true, now)
+	}
+	assertCircuit(t, b, "/card/get", now, CircuitOpen)
+
+	b.record("/card/get", slow, false, now)
+	assertCircuit(t, b, "/card/get", now, CircuitOpen)
+
+	now = now.Add(time.Second)
+	trial, err := b.allow("/card/get", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.record("/card/get", slow, false, now)
+	assertCircuit(t, b, "/card/get", now, CircuitHalfOpen)
+
+	// 无法判断结果的试探请求归还名额，下一个试探请求可以发送
+	b.release("/card/get", trial, now)
+	trial, err = b.allow("/card/get", now)
+	if err != nil {
+		t.Fatalf("归还试探名额后应放行，实际返回 %v", err)
+	}
+	b.record("/card/get", trial, false, now)
+	assertCircuit(t, b, "/card/get", now, CircuitClosed)
+}
+
+// TestCircuitBreakerInterceptor 5xx和系统繁忙视为失败，业务错误码视为接口可用，熔断后不再发送请求
+func TestCircuitBreakerInterceptor(t *testing.T) {
+	var calls int32
+	srv := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/card/get":
+			fmt.Fprint(rw, `{"errcode":40073,"errmsg":"invalid card id"}`)
+		case "/card/batchget":
+			fmt.Fprint(rw, `{"errcode":-1,"errmsg":"system error"}`)
+		default:
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	w := newTestClient(t, srv.URL, WithRetryPolicy(NoRetryPolicy()), WithCircuitBreaker(testBreakerConfig))
+	do := func(path string) error {
+		req, err := w.NewRequest("POST", path, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Do(context.Background(), req, nil)
+		return err
+	}
+
+	for _, path := range []string{"card/get", "card/batchget", "card/membercard/activate"} {
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < testBreakerConfig.FailureThreshold+1; i++ {
+			do(path)
+		}
+		wantCalls, wantState := int32(testBreakerConfig.FailureThreshold), CircuitOpen
+		if path == "card/get" {
+			wantCalls, wantState = int32(testBreakerConfig.FailureThreshold+1), CircuitClosed
+		}
+		if n := atomic.LoadInt32(&calls); n != wantCalls {
+			t.Errorf("%s 发送了%d次，应为%d次", path, n, wantCalls)
+		}
+		if state := w.CircuitStateOf(path); state != wantState {
+			t.Errorf("%s 的熔断状态为 %s，应为 %s", path, state, wantState)
+		}
+	}
+	if err := do("card/batchget"); !IsCircuitOpen(err) {
+		t.Fatalf("熔断后应返回*CircuitOpenError，实际返回 %v", err)
+	}
+}
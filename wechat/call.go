@@ -0,0 +1,50 @@
+package wechat
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Call 调用任意微信公众平台接口，用于还没有封装的接口
+// path为相对BaseURL的接口路径，如 cgi-bin/menu/get，可以带查询参数，access_token会自动加入
+// body不为nil时以json发送，返回数据以json解析到resp，resp为nil时只检查errcode
+// errcode不为0时返回*APIError，与封装好的接口一样会在AccessToken失效时刷新重试
+func (w *APIClient) Call(ctx context.Context, method, path string, body, resp interface{}) error {
+	return w.call(ctx, method, path, nil, body, resp)
+}
+
+// CallGet 以GET调用任意微信公众平台接口，query为额外的查询参数，其他与Call相同
+func (w *APIClient) CallGet(ctx context.Context, path string, query url.Values, resp interface{}) error {
+	return w.call(ctx, "GET", path, query, nil, resp)
+}
+
+func (w *APIClient) call(ctx context.Context, method, path string, query url.Values, body, resp interface{}) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// 去掉开头的/，使路径相对于BaseURL拼接，保留BaseURL中的路径前缀
+	rel, err := url.Parse(strings.TrimLeft(path, "/"))
+	if err != nil {
+		return err
+	}
+	token, err := w.GetAccessTokenContext(ctx)
+	if err != nil {
+		return err
+	}
+	params := rel.Query()
+	for key, values := range query {
+		for _, value := range values {
+			params.Add(key, value)
+		}
+	}
+	params.Set("access_token", token)
+	rel.RawQuery = params.Encode()
+
+	req, err := w.NewRequest(method, rel.String(), body)
+	if err != nil {
+		return err
+	}
+	_, err = w.Do(ctx, req, resp)
+	return err
+}
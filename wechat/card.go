@@ -6,16 +6,16 @@ type CardService service
 const (
 	// 微信卡券相关URL
 
-	urlGetCardList         = "https://api.weixin.qq.com/card/batchget?access_token=%s"                        // 获取帐号卡列表
-	urlGetCardInfo         = "https://api.weixin.qq.com/card/get?access_token=%s"                             // 获取卡信息
-	urlGetUserCards        = "https://api.weixin.qq.com/card/user/getcardlist?access_token=%s"                // 获取用户的卡券
-	urlGetCardUserInfo     = "https://api.weixin.qq.com/card/membercard/userinfo/get?access_token=%s"         // 获取卡券用户填写的信息
-	urlGetCardUserActivate = "https://api.weixin.qq.com/card/membercard/activatetempinfo/get?access_token=%s" // 获取微信会员激活会员卡的输入字段参数
-
-	urlUpdateCard         = "https://api.weixin.qq.com/card/update?access_token=%s"                          // 更新卡信息
-	urlActivateMemberCard = "https://api.weixin.qq.com/card/membercard/activate?access_token=%s"             // 激活用户指定的会员卡接口
-	urlActivateCardFlag   = "https://api.weixin.qq.com/card/membercard/activateuserform/set?access_token=%s" // 设置会员卡激活输入字段接口
-	urlCardCodeDecrypt    = "https://api.weixin.qq.com/card/code/decrypt?access_token=%s"                    // 微信卡券卡号解码接口
+	urlGetCardList         = "card/batchget?access_token=%s"                        // 获取帐号卡列表
+	urlGetCardInfo         = "card/get?access_token=%s"                             // 获取卡信息
+	urlGetUserCards        = "card/user/getcardlist?access_token=%s"                // 获取用户的卡券
+	urlGetCardUserInfo     = "card/membercard/userinfo/get?access_token=%s"         // 获取卡券用户填写的信息
+	urlGetCardUserActivate = "card/membercard/activatetempinfo/get?access_token=%s" // 获取微信会员激活会员卡的输入字段参数
+
+	urlUpdateCard         = "card/update?access_token=%s"                          // 更新卡信息
+	urlActivateMemberCard = "card/membercard/activate?access_token=%s"             // 激活用户指定的会员卡接口
+	urlActivateCardFlag   = "card/membercard/activateuserform/set?access_token=%s" // 设置会员卡激活输入字段接口
+	urlCardCodeDecrypt    = "card/code/decrypt?access_token=%s"                    // 微信卡券卡号解码接口
 
 	// 微信卡券类型
 
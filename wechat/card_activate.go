@@ -1,10 +1,8 @@
 package wechat
 
 import (
+	"context"
 	"fmt"
-	"log"
-
-	"github.com/gotit/errors"
 )
 
 /*
@@ -17,6 +15,11 @@ import (
 
 // SetActivateJump 设置微信激活后跳转连接
 func (c *CardService) SetActivateJump(submitURL, levelURL, couponURL string) error {
+	return c.SetActivateJumpContext(context.Background(), submitURL, levelURL, couponURL)
+}
+
+// SetActivateJumpContext 与SetActivateJump相同，通过ctx控制请求的超时与取消
+func (c *CardService) SetActivateJumpContext(ctx context.Context, submitURL, levelURL, couponURL string) error {
 	type CustomField struct {
 		NameType string `json:"name_type"`
 		URL      string `json:"url"`
@@ -46,7 +49,7 @@ func (c *CardService) SetActivateJump(submitURL, levelURL, couponURL string) err
 		"card_id":     c.wechat.MemberCardID,
 		"member_card": activate,
 	}
-	token, err := c.wechat.GetAccessToken()
+	token, err := c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -55,18 +58,11 @@ func (c *CardService) SetActivateJump(submitURL, levelURL, couponURL string) err
 	if err != nil {
 		return err
 	}
-	result := &struct {
-		Errcode int    `json:"errcode"`
-		Errmsg  string `json:"errmsg"`
-	}{}
-	_, err = c.wechat.Do(nil, req, result)
+	_, err = c.wechat.Do(ctx, req, nil)
 	if err != nil {
 		return err
 	}
-	if result.Errcode != 0 {
-		return errors.Errorf("更新会员卡失败 %s", result.Errmsg)
-	}
-	return c.SetActivateFlag()
+	return c.SetActivateFlagContext(ctx)
 }
 
 /*
@@ -85,6 +81,11 @@ SetActivateFlag 设置微信一键激活参数
 		OptionalForm     fieldForm       `json:"optional_form"`     // 会员卡激活时的选填项
 	}*/
 func (c *CardService) SetActivateFlag() error {
+	return c.SetActivateFlagContext(context.Background())
+}
+
+// SetActivateFlagContext 与SetActivateFlag相同，通过ctx控制请求的超时与取消
+func (c *CardService) SetActivateFlagContext(ctx context.Context) error {
 	type fieldFormSelect struct {
 		Type   string   `json:"type"`   // 富文本类型 FORM_FIELD_RADIO 自定义单选, FORM_FIELD_SELECT 自定义选择项, FORM_FIELD_CHECK_BOX 自定义多选
 		Name   string   `json:"name"`   // 字段名
@@ -114,7 +115,7 @@ func (c *CardService) SetActivateFlag() error {
 		"required_form": required,
 		"optional_form": optional,
 	}
-	token, err := c.wechat.GetAccessToken()
+	token, err := c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -123,27 +124,25 @@ func (c *CardService) SetActivateFlag() error {
 	if err != nil {
 		return err
 	}
-	result := &struct {
-		Errcode int    `json:"errcode"`
-		Errmsg  string `json:"errmsg"`
-	}{}
-	_, err = c.wechat.Do(nil, req, result)
+	_, err = c.wechat.Do(ctx, req, nil)
 	if err != nil {
 		return err
 	}
-	if result.Errcode != 0 {
-		return errors.Errorf("设置微信一键激活参数失败 %s", result.Errmsg)
-	}
 	return nil
 }
 
 // GetUseSubmitParam 获取微信指定用户提交的激活信息
 func (c *CardService) GetUseSubmitParam(encryptCode, openid, activateTicket string) (*CardMemberInfo, error) {
+	return c.GetUseSubmitParamContext(context.Background(), encryptCode, openid, activateTicket)
+}
+
+// GetUseSubmitParamContext 与GetUseSubmitParam相同，通过ctx控制请求的超时与取消
+func (c *CardService) GetUseSubmitParamContext(ctx context.Context, encryptCode, openid, activateTicket string) (*CardMemberInfo, error) {
 	// 用解码结果获取激活信息
 	param := map[string]interface{}{
 		"activate_ticket": activateTicket,
 	}
-	token, err := c.wechat.GetAccessToken()
+	token, err := c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -153,27 +152,21 @@ func (c *CardService) GetUseSubmitParam(encryptCode, openid, activateTicket stri
 		return nil, err
 	}
 	memberInfoResult := &struct {
-		Errcode int    `json:"errcode"`
-		Errmsg  string `json:"errmsg"`
-		Info    struct {
+		Info struct {
 			CommonFieldList []CardMemberField `json:"common_field_list"`
 			CustomFieldList []CardMemberField `json:"custom_field_list"`
 		} `json:"info"`
 	}{}
-	_, err = c.wechat.Do(nil, req, memberInfoResult)
+	_, err = c.wechat.Do(ctx, req, memberInfoResult)
 	if err != nil {
 		return nil, err
 	}
-	if memberInfoResult.Errcode != 0 {
-		log.Printf("获取激活信息错误了，这个错误八成是微信是bug %v", memberInfoResult)
-		return nil, nil
-	}
 
 	// 调用微信接口解码卡号
 	param = map[string]interface{}{
 		"encrypt_code": encryptCode,
 	}
-	token, err = c.wechat.GetAccessToken()
+	token, err = c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -183,17 +176,12 @@ func (c *CardService) GetUseSubmitParam(encryptCode, openid, activateTicket stri
 		return nil, err
 	}
 	cardCodeResult := &struct {
-		Errcode int    `json:"errcode"`
-		Errmsg  string `json:"errmsg"`
-		Code    string `json:"code"`
+		Code string `json:"code"`
 	}{}
-	_, err = c.wechat.Do(nil, req, cardCodeResult)
+	_, err = c.wechat.Do(ctx, req, cardCodeResult)
 	if err != nil {
 		return nil, err
 	}
-	if cardCodeResult.Errcode != 0 {
-		return nil, errors.Errorf("解码会员卡号出错 %s", cardCodeResult.Errmsg)
-	}
 
 	// 调用微信接口激活会员卡
 	param = map[string]interface{}{
@@ -201,7 +189,7 @@ func (c *CardService) GetUseSubmitParam(encryptCode, openid, activateTicket stri
 		"code":              cardCodeResult.Code,
 		"card_id":           c.wechat.MemberCardID,
 	}
-	token, err = c.wechat.GetAccessToken()
+	token, err = c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -210,23 +198,16 @@ func (c *CardService) GetUseSubmitParam(encryptCode, openid, activateTicket stri
 	if err != nil {
 		return nil, err
 	}
-	activateResult := &struct {
-		Errcode int    `json:"errcode"`
-		Errmsg  string `json:"errmsg"`
-	}{}
-	_, err = c.wechat.Do(nil, req, activateResult)
+	_, err = c.wechat.Do(ctx, req, nil)
 	if err != nil {
 		return nil, err
 	}
-	if activateResult.Errcode != 0 {
-		return nil, errors.Errorf("获取用户提交的激活信息失败 %s", activateResult.Errmsg)
-	}
 
-	userInfo, err := c.wechat.User.GetUserInfoByOpenid(openid)
+	userInfo, err := c.wechat.User.GetUserInfoByOpenidContext(ctx, openid)
 	if err != nil {
 		return nil, err
 	}
-	mobileNumber, gender, realname, birthday := unmarshalCardMemberFields(memberInfoResult.Info.CommonFieldList)
+	mobileNumber, gender, realname, birthday := unmarshalCardMemberFields(c.wechat.logger, memberInfoResult.Info.CommonFieldList)
 	baby1, baby2 := unmarshalCardMemberCustomFields(memberInfoResult.Info.CustomFieldList)
 	memberInfo := &CardMemberInfo{
 		CardID:       c.wechat.MemberCardID,
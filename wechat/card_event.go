@@ -0,0 +1,204 @@
+package wechat
+
+import (
+	"encoding/xml"
+
+	"github.com/gotit/errors"
+)
+
+// 卡券事件类型 Event
+const (
+	EventCardPassCheck            = "card_pass_check"              // 卡券审核通过
+	EventCardNotPassCheck         = "card_not_pass_check"          // 卡券审核未通过
+	EventUserGetCard              = "user_get_card"                // 用户领取卡券
+	EventUserGiftingCard          = "user_gifting_card"            // 用户转赠卡券
+	EventUserDelCard              = "user_del_card"                // 用户删除卡券
+	EventUserConsumeCard          = "user_consume_card"            // 卡券被核销
+	EventUserViewCard             = "user_view_card"               // 用户进入会员卡
+	EventUserEnterSessionFromCard = "user_enter_session_from_card" // 用户从卡券进入公众号会话
+	EventSubmitMemberCardUserInfo = "submit_membercard_user_info"  // 用户提交会员卡激活资料
+	EventUpdateMemberCard         = "update_member_card"           // 会员卡积分余额变更
+	EventCardSkuRemind            = "card_sku_remind"              // 卡券库存报警
+	EventCardPayOrder             = "card_pay_order"               // 券点流水变动
+)
+
+// CardEvent 卡券相关的事件，都可以通过Card获取卡券的公共字段
+type CardEvent interface {
+	TypedMessage
+	Card() *CardEventHeader
+}
+
+// CardEventHandler 处理一个卡券事件，返回error时记录日志并回复success
+type CardEventHandler func(c *Context, e CardEvent) error
+
+// CardEventHeader 卡券事件共有的字段，不涉及具体卡券的事件中为空
+type CardEventHeader struct {
+	EventHeader
+	CardID       string `xml:"CardId"`       // 卡券ID
+	UserCardCode string `xml:"UserCardCode"` // 卡券Code码
+}
+
+// Card 返回卡券的公共字段
+func (h *CardEventHeader) Card() *CardEventHeader {
+	return h
+}
+
+// CardCheckEvent 卡券审核事件，Event为card_pass_check或card_not_pass_check
+type CardCheckEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	RefuseReason string `xml:"RefuseReason"` // 审核不通过的原因
+}
+
+// UserGetCardEvent 用户领取卡券事件
+type UserGetCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	IsGiveByFriend      int    `xml:"IsGiveByFriend"`      // 是否为转赠领取，1为是
+	FriendUserName      string `xml:"FriendUserName"`      // 转赠时赠送方的openid
+	OuterID             int    `xml:"OuterId"`             // 领取场景值
+	OldUserCardCode     string `xml:"OldUserCardCode"`     // 转赠前的卡券Code码
+	OuterStr            string `xml:"OuterStr"`            // 领取场景值，开发者在领取链接中设置的outer_str
+	IsRestoreMemberCard int    `xml:"IsRestoreMemberCard"` // 是否为删除后重新领取的会员卡，1为是
+	UnionID             string `xml:"UnionId"`             // 领券用户的unionid
+}
+
+// UserGiftingCardEvent 用户转赠卡券事件
+type UserGiftingCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	IsReturnBack   int    `xml:"IsReturnBack"`   // 是否为转赠退回，1为是
+	FriendUserName string `xml:"FriendUserName"` // 接收方的openid
+	IsChatRoom     int    `xml:"IsChatRoom"`     // 是否转赠到群，1为是
+}
+
+// UserDelCardEvent 用户删除卡券事件
+type UserDelCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+}
+
+// UserConsumeCardEvent 卡券被核销事件
+type UserConsumeCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	ConsumeSource string `xml:"ConsumeSource"` // 核销来源，如FROM_API、FROM_MOBILE_HELPER
+	LocationName  string `xml:"LocationName"`  // 门店名称
+	LocationID    int64  `xml:"LocationId"`    // 门店ID
+	StaffOpenID   string `xml:"StaffOpenId"`   // 核销员的openid
+	VerifyCode    string `xml:"VerifyCode"`    // 自助核销时用户输入的验证码
+	RemarkAmount  string `xml:"RemarkAmount"`  // 自助核销时用户输入的备注金额
+	OuterStr      string `xml:"OuterStr"`      // 领取链接中设置的outer_str
+}
+
+// UserViewCardEvent 用户进入会员卡事件
+type UserViewCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	OuterStr string `xml:"OuterStr"` // 进入场景值，开发者在链接中设置的outer_str
+}
+
+// UserEnterSessionFromCardEvent 用户从卡券进入公众号会话事件
+type UserEnterSessionFromCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+}
+
+// SubmitMemberCardUserInfoEvent 用户提交会员卡激活资料事件
+// 跳转型一键激活时，收到后可以用GetMemberByCode获取用户提交的资料并激活
+type SubmitMemberCardUserInfoEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+}
+
+// UpdateMemberCardEvent 会员卡积分余额变更事件
+type UpdateMemberCardEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	ModifyBonus   int `xml:"ModifyBonus"`   // 变动的积分值
+	ModifyBalance int `xml:"ModifyBalance"` // 变动的余额值
+}
+
+// CardSkuRemindEvent 卡券库存报警事件，库存小于100时推送
+type CardSkuRemindEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	Detail string `xml:"Detail"` // 报警详细信息
+}
+
+// CardPayOrderEvent 券点流水变动事件，CardID和UserCardCode为空
+type CardPayOrderEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	CardEventHeader
+	OrderID             string `xml:"OrderId"`             // 本次推送对应的订单号
+	Status              string `xml:"Status"`              // 订单状态，如ORDER_STATUS_SUCC
+	CreateOrderTime     int64  `xml:"CreateOrderTime"`     // 订单创建时间
+	PayFinishTime       int64  `xml:"PayFinishTime"`       // 支付完成时间
+	Desc                string `xml:"Desc"`                // 支付方式说明
+	FreeCoinCount       string `xml:"FreeCoinCount"`       // 剩余免费券点数量
+	PayCoinCount        string `xml:"PayCoinCount"`        // 剩余付费券点数量
+	RefundFreeCoinCount string `xml:"RefundFreeCoinCount"` // 本次退回的免费券点数量
+	RefundPayCoinCount  string `xml:"RefundPayCoinCount"`  // 本次退回的付费券点数量
+	OrderType           string `xml:"OrderType"`           // 订单类型，如ORDER_TYPE_WXPAY
+	Memo                string `xml:"Memo"`                // 系统备注
+	ReceiptInfo         string `xml:"ReceiptInfo"`         // 发票信息
+}
+
+// cardEventTypes 卡券事件对应的结构体，注册到messageTypes
+var cardEventTypes = map[string]func() TypedMessage{
+	EventCardPassCheck:            func() TypedMessage { return &CardCheckEvent{} },
+	EventCardNotPassCheck:         func() TypedMessage { return &CardCheckEvent{} },
+	EventUserGetCard:              func() TypedMessage { return &UserGetCardEvent{} },
+	EventUserGiftingCard:          func() TypedMessage { return &UserGiftingCardEvent{} },
+	EventUserDelCard:              func() TypedMessage { return &UserDelCardEvent{} },
+	EventUserConsumeCard:          func() TypedMessage { return &UserConsumeCardEvent{} },
+	EventUserViewCard:             func() TypedMessage { return &UserViewCardEvent{} },
+	EventUserEnterSessionFromCard: func() TypedMessage { return &UserEnterSessionFromCardEvent{} },
+	EventSubmitMemberCardUserInfo: func() TypedMessage { return &SubmitMemberCardUserInfoEvent{} },
+	EventUpdateMemberCard:         func() TypedMessage { return &UpdateMemberCardEvent{} },
+	EventCardSkuRemind:            func() TypedMessage { return &CardSkuRemindEvent{} },
+	EventCardPayOrder:             func() TypedMessage { return &CardPayOrderEvent{} },
+}
+
+func init() {
+	for event, newEvent := range cardEventTypes {
+		messageTypes[eventTypeKey(event)] = newEvent
+	}
+}
+
+// HandleCardEvent 注册卡券事件event的处理函数，事件已解析为对应的结构体，如*UserGetCardEvent
+func (s *Server) HandleCardEvent(event string, h CardEventHandler) {
+	s.HandleEvent(event, cardEventHandler(h))
+}
+
+// HandleCardEvents 为所有卡券事件注册同一个处理函数，可以按e的类型分别处理
+// 之后用HandleCardEvent或HandleEvent注册的单个事件会覆盖这里的处理函数
+func (s *Server) HandleCardEvents(h CardEventHandler) {
+	for event := range cardEventTypes {
+		s.HandleEvent(event, cardEventHandler(h))
+	}
+}
+
+// cardEventHandler 把CardEventHandler转为MessageHandler
+func cardEventHandler(h CardEventHandler) MessageHandler {
+	return func(c *Context) error {
+		e, err := c.CardEvent()
+		if err != nil {
+			return err
+		}
+		return h(c, e)
+	}
+}
+
+// CardEvent 把推送解析为卡券事件，不是卡券事件时返回error
+func (c *Context) CardEvent() (CardEvent, error) {
+	typed, err := c.TypedMessage()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := typed.(CardEvent)
+	if !ok {
+		return nil, errors.Errorf("推送的 %s 事件不是卡券事件", c.Message.Event)
+	}
+	return e, nil
+}
@@ -1,8 +1,8 @@
 package wechat
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -33,7 +33,12 @@ type CardMemberField struct {
 
 // GetMemberByCode 通过会员卡号获取会员信息
 func (c *CardService) GetMemberByCode(cardCode string) (*CardMemberInfo, error) {
-	token, err := c.wechat.GetAccessToken()
+	return c.GetMemberByCodeContext(context.Background(), cardCode)
+}
+
+// GetMemberByCodeContext 与GetMemberByCode相同，通过ctx控制请求的超时与取消
+func (c *CardService) GetMemberByCodeContext(ctx context.Context, cardCode string) (*CardMemberInfo, error) {
+	token, err := c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -48,8 +53,6 @@ func (c *CardService) GetMemberByCode(cardCode string) (*CardMemberInfo, error)
 		return nil, err
 	}
 	member := &struct {
-		Errcode          int    `json:"errcode"`           // 错误码，0为正常
-		Errmsg           string `json:"errmsg"`            // 错误信息
 		Openid           string `json:"openid"`            // 用户在本公众号内唯一识别码
 		Nickname         string `json:"nickname"`          // 用户昵称
 		MembershipNumber string `json:"membership_number"` // 积分信息
@@ -62,7 +65,7 @@ func (c *CardService) GetMemberByCode(cardCode string) (*CardMemberInfo, error)
 		UserCardStatus string `json:"user_card_status"` // 当前用户会员卡状态，NORMAL 正常 EXPIRE 已过期 GIFTING 转赠中 GIFT_SUCC 转赠成功 GIFT_TIMEOUT 转赠超时 DELETE 已删除，UNAVAILABLE 已失效
 		HasActive      bool   `json:"has_active"`       // 当前用户会员卡是否已激活
 	}{}
-	_, err = c.wechat.Do(nil, req, member)
+	_, err = c.wechat.Do(ctx, req, member)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +73,7 @@ func (c *CardService) GetMemberByCode(cardCode string) (*CardMemberInfo, error)
 		return nil, errors.New("用户还未激活会员卡")
 	}
 
-	mobileNumber, gender, realname, birthday := unmarshalCardMemberFields(member.UserInfo.CommonFieldList)
+	mobileNumber, gender, realname, birthday := unmarshalCardMemberFields(c.wechat.logger, member.UserInfo.CommonFieldList)
 	baby1, baby2 := unmarshalCardMemberCustomFields(member.UserInfo.CustomFieldList)
 	info := &CardMemberInfo{
 		CardID:       c.wechat.MemberCardID,
@@ -91,7 +94,12 @@ func (c *CardService) GetMemberByCode(cardCode string) (*CardMemberInfo, error)
 
 // GetMemberByOpenid 通过openid获取会员信息
 func (c *CardService) GetMemberByOpenid(openid string) (*CardMemberInfo, error) {
-	token, err := c.wechat.GetAccessToken()
+	return c.GetMemberByOpenidContext(context.Background(), openid)
+}
+
+// GetMemberByOpenidContext 与GetMemberByOpenid相同，通过ctx控制请求的超时与取消
+func (c *CardService) GetMemberByOpenidContext(ctx context.Context, openid string) (*CardMemberInfo, error) {
+	token, err := c.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -105,21 +113,16 @@ func (c *CardService) GetMemberByOpenid(openid string) (*CardMemberInfo, error)
 		return nil, err
 	}
 	result := &struct {
-		Errcode  int    `json:"errcode"`
-		Errmsg   string `json:"errmsg"`
 		CardList []struct {
 			CardID string `json:"card_id"`
 			Code   string `json:"code"`
 		} `json:"card_list"`
 		HasShareCard bool `json:"has_share_card"`
 	}{}
-	_, err = c.wechat.Do(nil, req, result)
+	_, err = c.wechat.Do(ctx, req, result)
 	if err != nil {
 		return nil, err
 	}
-	if result.Errcode != 0 {
-		return nil, errors.New("获取卡列表失败")
-	}
 	if len(result.CardList) == 0 {
 		return nil, errors.New("用户还没有领取过会员卡")
 	}
@@ -133,11 +136,11 @@ func (c *CardService) GetMemberByOpenid(openid string) (*CardMemberInfo, error)
 	if len(cardCode) == 0 {
 		return nil, errors.New("用户不是会员卡成员")
 	}
-	return c.GetMemberByCode(cardCode)
+	return c.GetMemberByCodeContext(ctx, cardCode)
 }
 
 // unmarshalCardMemberFields 解析卡会员的参数
-func unmarshalCardMemberFields(fields []CardMemberField) (mobile, gender, realName string, birthday time.Time) {
+func unmarshalCardMemberFields(logger Logger, fields []CardMemberField) (mobile, gender, realName string, birthday time.Time) {
 	mobile, gender, realName, birthday = "", genderOther, "", time.Now()
 
 	for _, field := range fields {
@@ -156,7 +159,7 @@ func unmarshalCardMemberFields(fields []CardMemberField) (mobile, gender, realNa
 			} else if b, e := time.Parse("2006-1-2", field.Value); e == nil {
 				birthday = b
 			} else {
-				log.Printf("特殊的生日格式：%s", field.Value)
+				logger.Warn("无法解析的生日格式", "length", len(field.Value))
 			}
 		case cardActivateSex:
 			if strings.EqualFold(field.Value, "男") {
@@ -164,7 +167,7 @@ func unmarshalCardMemberFields(fields []CardMemberField) (mobile, gender, realNa
 			} else if strings.EqualFold(field.Value, "女") {
 				gender = genderFemale
 			} else {
-				log.Print("其他性别格式 ", field.Value)
+				logger.Warn("无法解析的性别格式", "length", len(field.Value))
 			}
 		case cardActivateName:
 			realName = field.Value
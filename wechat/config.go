@@ -0,0 +1,176 @@
+package wechat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gotit/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const problemNilConfig = "APIConfig不能为nil"
+
+// ConfigError APIConfig或Option中的所有问题
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("APIConfig无效: %s", strings.Join(e.Problems, "; "))
+}
+
+// Validate 校验APIConfig，有问题时返回包含所有问题的*ConfigError
+func (c *APIConfig) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// problems 返回APIConfig中的所有问题
+func (c *APIConfig) problems() []string {
+	if c == nil {
+		return []string{problemNilConfig}
+	}
+	problems := []string{}
+
+	if len(c.AppID) == 0 {
+		problems = append(problems, "AppID不能为空")
+	} else if !strings.HasPrefix(c.AppID, "wx") || len(c.AppID) != 18 {
+		problems = append(problems, fmt.Sprintf("AppID %s 格式不正确，应为wx开头的18位字符", c.AppID))
+	}
+
+	if len(c.OriginalID) > 0 && !strings.HasPrefix(c.OriginalID, "gh_") {
+		problems = append(problems, fmt.Sprintf("OriginalID %s 格式不正确，应为gh_开头", c.OriginalID))
+	}
+
+	needSecret := c.AccessTokenCachePolicy == CachePolicyAutonomy || c.AccessTokenStable
+	if len(c.AppSecret) == 0 && needSecret {
+		problems = append(problems, "自治维护或使用稳定版AccessToken时AppSecret不能为空")
+	} else if len(c.AppSecret) > 0 && len(c.AppSecret) != 32 {
+		problems = append(problems, "AppSecret格式不正确，应为32位字符")
+	}
+
+	if len(c.MchID) > 0 {
+		if _, err := strconv.ParseUint(c.MchID, 10, 64); err != nil {
+			problems = append(problems, fmt.Sprintf("MchID %s 格式不正确，应为数字", c.MchID))
+		}
+		if len(c.MchSecret) == 0 {
+			problems = append(problems, "设置了MchID时MchSecret不能为空")
+		}
+	} else if len(c.MchSecret) > 0 {
+		problems = append(problems, "设置了MchSecret时MchID不能为空")
+	}
+	if len(c.MchSecret) > 0 && len(c.MchSecret) != 32 {
+		problems = append(problems, "MchSecret格式不正确，应为32位字符")
+	}
+
+	if _, ok := logLevels[c.LogLevel]; !ok && len(c.LogLevel) > 0 {
+		problems = append(problems, fmt.Sprintf("未知的LogLevel %s", c.LogLevel))
+	}
+
+	switch c.AccessTokenCachePolicy {
+	case CachePolicyNone, CachePolicyAutonomy:
+	case CachePolicyHTTP:
+		u, err := url.Parse(c.AccessTokenCacheAddress)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("中控服务器地址 %q 无效，应为http://或https://开头的地址", c.AccessTokenCacheAddress))
+		}
+	case "":
+		problems = append(problems, "AccessTokenCachePolicy不能为空")
+	default:
+		problems = append(problems, fmt.Sprintf("未知的AccessTokenCachePolicy %s", c.AccessTokenCachePolicy))
+	}
+	return problems
+}
+
+/*
+LoadConfigFromEnv 从环境变量加载APIConfig，prefix为空时使用 WECHAT_
+
+	{prefix}APP_ID                      AppID
+	{prefix}APP_SECRET                  AppSecret
+	{prefix}ORIGINAL_ID                 OriginalID
+	{prefix}MCH_ID                      MchID
+	{prefix}MCH_SECRET                  MchSecret
+	{prefix}MEMBER_CARD_ID              MemberCardID
+	{prefix}ACCESS_TOKEN_CACHE_POLICY   AccessTokenCachePolicy
+	{prefix}ACCESS_TOKEN_CACHE_ADDRESS  AccessTokenCacheAddress
+	{prefix}ACCESS_TOKEN_CACHE_SECRET   AccessTokenCacheSecret
+	{prefix}ACCESS_TOKEN_STABLE         AccessTokenStable，true/false
+	{prefix}TOKEN_STORE_DIR             TokenStoreDir
+	{prefix}LOG_LEVEL                   LogLevel
+*/
+func LoadConfigFromEnv(prefix string) (*APIConfig, error) {
+	if len(prefix) == 0 {
+		prefix = "WECHAT_"
+	}
+	env := func(name string) string {
+		return os.Getenv(prefix + name)
+	}
+	config := &APIConfig{
+		AppID:                   env("APP_ID"),
+		AppSecret:               env("APP_SECRET"),
+		OriginalID:              env("ORIGINAL_ID"),
+		MchID:                   env("MCH_ID"),
+		MchSecret:               env("MCH_SECRET"),
+		MemberCardID:            env("MEMBER_CARD_ID"),
+		AccessTokenCachePolicy:  env("ACCESS_TOKEN_CACHE_POLICY"),
+		AccessTokenCacheAddress: env("ACCESS_TOKEN_CACHE_ADDRESS"),
+		AccessTokenCacheSecret:  env("ACCESS_TOKEN_CACHE_SECRET"),
+		TokenStoreDir:           env("TOKEN_STORE_DIR"),
+		LogLevel:                env("LOG_LEVEL"),
+	}
+	if stable := env("ACCESS_TOKEN_STABLE"); len(stable) > 0 {
+		b, err := strconv.ParseBool(stable)
+		if err != nil {
+			return nil, errors.Errorf("环境变量 %sACCESS_TOKEN_STABLE 的值 %s 无效", prefix, stable)
+		}
+		config.AccessTokenStable = b
+	}
+	return config, nil
+}
+
+// LoadConfigFile 从json或yaml文件加载APIConfig，根据扩展名 .json .yaml .yml 判断格式
+// 字段名与APIConfig的json标签一致，如 app_id、access_token_cache_policy
+func LoadConfigFile(path string) (*APIConfig, error) {
+	config := &APIConfig{}
+	if err := unmarshalConfigFile(path, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfigsFile 从json或yaml文件加载多个公众号的APIConfig，文件内容为APIConfig的数组，用于NewRegistry
+func LoadConfigsFile(path string) ([]*APIConfig, error) {
+	configs := []*APIConfig{}
+	if err := unmarshalConfigFile(path, &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// unmarshalConfigFile 根据扩展名解析json或yaml配置文件到v
+func unmarshalConfigFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	default:
+		return errors.Errorf("不支持的配置文件格式 %s", path)
+	}
+	if err != nil {
+		return errors.Errorf("解析配置文件 %s 失败 %s", path, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gotit/errors"
+)
+
+const cryptBlockSize = 32 // 微信消息加密使用的PKCS#7填充块大小
+
+// ErrInvalidMsgSignature 安全模式下推送消息的msg_signature无效
+var ErrInvalidMsgSignature = errors.New("msg_signature无效")
+
+// ErrInvalidMsgAppID 解密后的消息不属于本公众号
+var ErrInvalidMsgAppID = errors.New("消息的AppID与公众号不一致")
+
+// MessageCrypter 安全模式下微信推送消息和被动回复的加解密
+// 算法为AES-256-CBC，密钥为EncodingAESKey解码后的32字节，IV为密钥的前16字节，PKCS#7填充
+// 明文为 16字节随机数 + 4字节网络字节序的消息长度 + 消息 + AppID
+type MessageCrypter struct {
+	token  string
+	appID  string
+	key    []byte
+	block  cipher.Block
+	random io.Reader // 生成明文开头的16字节随机数
+}
+
+// encryptedEnvelope 安全模式下推送消息和被动回复的外层XML
+type encryptedEnvelope struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName,omitempty"`
+	Encrypt      cdata    `xml:"Encrypt"`
+	MsgSignature cdata    `xml:"MsgSignature,omitempty"`
+	TimeStamp    string   `xml:"TimeStamp,omitempty"`
+	Nonce        cdata    `xml:"Nonce,omitempty"`
+}
+
+// cdata 以CDATA输出的XML文本
+type cdata string
+
+func (c cdata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Text string `xml:",cdata"`
+	}{string(c)}, start)
+}
+
+// NewMessageCrypter 创建消息加解密器，token为服务器配置的令牌，encodingAESKey为43位的消息加解密密钥
+func NewMessageCrypter(token, encodingAESKey, appID string) (*MessageCrypter, error) {
+	if len(encodingAESKey) != 43 {
+		return nil, errors.New("EncodingAESKey应为43位字符")
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("EncodingAESKey格式不正确")
+	}
+	if len(appID) == 0 {
+		return nil, errors.New("AppID不能为空")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageCrypter{token: token, appID: appID, key: key, block: block, random: rand.Reader}, nil
+}
+
+// Signature 计算msg_signature，即token、timestamp、nonce、encrypt字典序排序拼接后的sha1
+func (c *MessageCrypter) Signature(timestamp, nonce, encrypt string) string {
+	strs := []string{c.token, timestamp, nonce, encrypt}
+	sort.Strings(strs)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(strs, ""))))
+}
+
+// Decrypt 校验msg_signature并解密推送消息的Encrypt字段，返回消息的XML
+func (c *MessageCrypter) Decrypt(msgSignature, timestamp, nonce, encrypt string) ([]byte, error) {
+	expected := c.Signature(timestamp, nonce, encrypt)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(msgSignature))) != 1 {
+		return nil, ErrInvalidMsgSignature
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypt)
+	if err != nil {
+		return nil, errors.Errorf("Encrypt不是合法的base64 %s", err.Error())
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("Encrypt的长度不正确")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(c.block, c.key[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext)
+
+	plaintext, err = pkcs7Unpad(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) < 20 {
+		return nil, errors.New("解密后的消息长度不正确")
+	}
+	// 先以uint64比较长度再转为int，32位平台上超过2^31的长度转为int会变成负数
+	rawLen := binary.BigEndian.Uint32(plaintext[16:20])
+	if uint64(rawLen) > uint64(len(plaintext)-20) {
+		return nil, errors.New("解密后的消息长度不正确")
+	}
+	msgLen := int(rawLen)
+	msg := plaintext[20 : 20+msgLen]
+	if string(plaintext[20+msgLen:]) != c.appID {
+		return nil, ErrInvalidMsgAppID
+	}
+	return msg, nil
+}
+
+// Encrypt 加密消息，返回base64编码的密文
+func (c *MessageCrypter) Encrypt(msg []byte) (string, error) {
+	plaintext := make([]byte, 20, 20+len(msg)+len(c.appID)+cryptBlockSize)
+	if _, err := io.ReadFull(c.random, plaintext[:16]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint32(plaintext[16:20], uint32(len(msg)))
+	plaintext = append(plaintext, msg...)
+	plaintext = append(plaintext, c.appID...)
+	plaintext = pkcs7Pad(plaintext)
+
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(c.block, c.key[:aes.BlockSize]).CryptBlocks(ciphertext, plaintext)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// EncryptReply 加密被动回复，返回包含Encrypt、MsgSignature、TimeStamp、Nonce的XML
+func (c *MessageCrypter) EncryptReply(reply []byte, timestamp, nonce string) ([]byte, error) {
+	encrypt, err := c.Encrypt(reply)
+	if err != nil {
+		return nil, err
+	}
+	return xml.Marshal(&encryptedEnvelope{
+		Encrypt:      cdata(encrypt),
+		MsgSignature: cdata(c.Signature(timestamp, nonce, encrypt)),
+		TimeStamp:    timestamp,
+		Nonce:        cdata(nonce),
+	})
+}
+
+// pkcs7Pad 按cryptBlockSize进行PKCS#7填充
+func pkcs7Pad(data []byte) []byte {
+	n := cryptBlockSize - len(data)%cryptBlockSize
+	return append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+// pkcs7Unpad 去掉PKCS#7填充
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("解密后的消息为空")
+	}
+	n := int(data[len(data)-1])
+	if n < 1 || n > cryptBlockSize || n > len(data) {
+		return nil, errors.New("解密后的消息填充不正确")
+	}
+	return data[:len(data)-n], nil
+}
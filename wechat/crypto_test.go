@@ -0,0 +1,196 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// 微信公众平台消息加解密示例代码中的测试数据，明文开头的随机数固定为 aaaabbbbccccdddd
+const (
+	sampleToken          = "pamtest"
+	sampleEncodingAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+	sampleAppID          = "wxb11529c136998cb6"
+	sampleRandom         = "aaaabbbbccccdddd"
+	sampleTimestamp      = "1409304348"
+	sampleNonce          = "xxxxxx"
+)
+
+var cryptSamples = []struct {
+	msg     string
+	encrypt string
+}{
+	{
+		msg:     "我是中文abcd123",
+		encrypt: "jn1L23DB+6ELqJ+6bruv21Y6MD7KeIfP82D6gU39rmkgczbWwt5+3bnyg5K55bgVtVzd832WzZGMhkP72vVOfg==",
+	},
+	{
+		msg:     "<xml><ToUserName><![CDATA[oia2Tj我是中文jewbmiOUlr6X-1crbLOvLw]]></ToUserName><FromUserName><![CDATA[gh_7f083739789a]]></FromUserName><CreateTime>1407743423</CreateTime><MsgType><![CDATA[video]]></MsgType><Video><MediaId><![CDATA[eYJ1MbwPRJtOvIEabaxHs7TX2D-HV71s79GUxqdUkjm6Gs2Ed1KF3ulAOA9H1xG0]]></MediaId><Title><![CDATA[testCallBackReplyVideo]]></Title><Description><![CDATA[testCallBackReplyVideo]]></Description></Video></xml>",
+		encrypt: "jn1L23DB+6ELqJ+6bruv23M2GmYfkv0xBh2h+XTBOKVKcgDFHle6gqcZ1cZrk3e1qjPQ1F4RsLWzQRG9udbKWesxlkupqcEcW7ZQweImX9+wLMa0GaUzpkycA8+IamDBxn5loLgZpnS7fVAbExOkK5DYHBmv5tptA9tklE/fTIILHR8HLXa5nQvFb3tYPKAlHF3rtTeayNf0QuM+UW/wM9enGIDIJHF7CLHiDNAYxr+r+OrJCmPQyTy8cVWlu9iSvOHPT/77bZqJucQHQ04sq7KZI27OcqpQNSto2OdHCoTccjggX5Z9Mma0nMJBU+jLKJ38YB1fBIz+vBzsYjrTmFQ44YfeEuZ+xRTQwr92vhA9OxchWVINGC50qE/6lmkwWTwGX9wtQpsJKhP+oS7rvTY8+VdzETdfakjkwQ5/Xka042OlUb1/slTwo4RscuQ+RdxSGvDahxAJ6+EAjLt9d8igHngxIbf6YyqqROxuxqIeIch3CssH/LqRs+iAcILvApYZckqmA7FNERspKA5f8GoJ9sv8xmGvZ9Yrf57cExWtnX8aCMMaBropU/1k+hKP5LVdzbWCG0hGwx/dQudYR/eXp3P0XxjlFiy+9DMlaFExWUZQDajPkdPrEeOwofJb",
+	},
+}
+
+func newSampleCrypter(t *testing.T, appID string) *MessageCrypter {
+	t.Helper()
+	c, err := NewMessageCrypter(sampleToken, sampleEncodingAESKey, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestMessageCrypterEncryptSample 固定随机数时，加密结果与微信示例逐字节一致
+func TestMessageCrypterEncryptSample(t *testing.T) {
+	c := newSampleCrypter(t, sampleAppID)
+	for _, sample := range cryptSamples {
+		c.random = strings.NewReader(sampleRandom)
+		encrypt, err := c.Encrypt([]byte(sample.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypt != sample.encrypt {
+			t.Fatalf("加密 %s\n得到 %s\n应为 %s", sample.msg, encrypt, sample.encrypt)
+		}
+	}
+}
+
+// TestMessageCrypterDecryptSample 解密微信示例的密文
+func TestMessageCrypterDecryptSample(t *testing.T) {
+	c := newSampleCrypter(t, sampleAppID)
+	for _, sample := range cryptSamples {
+		signature := c.Signature(sampleTimestamp, sampleNonce, sample.encrypt)
+		msg, err := c.Decrypt(signature, sampleTimestamp, sampleNonce, sample.encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != sample.msg {
+			t.Fatalf("解密得到 %s，应为 %s", msg, sample.msg)
+		}
+	}
+}
+
+// TestMessageCrypterVerifySample 微信示例中验证URL的请求，msg_signature和Encrypt均为示例中的原始数据
+// 该示例来自企业微信，算法与公众号相同，AppID的位置为企业的CorpID
+func TestMessageCrypterVerifySample(t *testing.T) {
+	c, err := NewMessageCrypter("QDG6eK", "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C", "wx5823bf96d3bd56c7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := "P9nAzCzyDtyTWESHep1vC5X9xho/qYX3Zpb4yKa9SKld1DsH3Iyt3tP3zNdtp+4RPcs8TgAE7OaBO+FZXvnaqQ=="
+	msg, err := c.Decrypt("5c45ff5e21c57e6ad56bac8758b79b1d9ac89fd3", "1409659589", "263014780", encrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "1616140317555161061" {
+		t.Fatalf("解密得到 %s", msg)
+	}
+}
+
+// TestMessageCrypterEncryptReply 加密的被动回复可以用其中的MsgSignature、TimeStamp、Nonce解密
+func TestMessageCrypterEncryptReply(t *testing.T) {
+	c := newSampleCrypter(t, sampleAppID)
+	reply := []byte(cryptSamples[1].msg)
+	data, err := c.EncryptReply(reply, sampleTimestamp, sampleNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := struct {
+		Encrypt      string `xml:"Encrypt"`
+		MsgSignature string `xml:"MsgSignature"`
+		TimeStamp    string `xml:"TimeStamp"`
+		Nonce        string `xml:"Nonce"`
+	}{}
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := c.Decrypt(envelope.MsgSignature, envelope.TimeStamp, envelope.Nonce, envelope.Encrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, reply) {
+		t.Fatalf("解密得到 %s", msg)
+	}
+}
+
+// TestMessageCrypterInvalidSignature msg_signature不正确时返回ErrInvalidMsgSignature
+func TestMessageCrypterInvalidSignature(t *testing.T) {
+	c := newSampleCrypter(t, sampleAppID)
+	encrypt := cryptSamples[0].encrypt
+	signature := c.Signature(sampleTimestamp, sampleNonce, encrypt)
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+	}{
+		{"篡改签名", "0" + signature[1:], sampleTimestamp},
+		{"空签名", "", sampleTimestamp},
+		{"篡改时间戳", signature, "1409304349"},
+	}
+	for _, tt := range tests {
+		if _, err := c.Decrypt(tt.signature, tt.timestamp, sampleNonce, encrypt); err != ErrInvalidMsgSignature {
+			t.Errorf("%s: 应返回ErrInvalidMsgSignature，实际返回 %v", tt.name, err)
+		}
+	}
+}
+
+// TestMessageCrypterInvalidAppID 消息属于其他公众号时返回ErrInvalidMsgAppID
+func TestMessageCrypterInvalidAppID(t *testing.T) {
+	c := newSampleCrypter(t, "wx0000000000000000")
+	encrypt := cryptSamples[0].encrypt
+	signature := c.Signature(sampleTimestamp, sampleNonce, encrypt)
+	if _, err := c.Decrypt(signature, sampleTimestamp, sampleNonce, encrypt); err != ErrInvalidMsgAppID {
+		t.Fatalf("应返回ErrInvalidMsgAppID，实际返回 %v", err)
+	}
+}
+
+// TestMessageCrypterInvalidCiphertext 填充、长度不正确的密文返回错误
+func TestMessageCrypterInvalidCiphertext(t *testing.T) {
+	c := newSampleCrypter(t, sampleAppID)
+	plaintext := func(msgLen uint32, pad byte) []byte {
+		p := make([]byte, 64)
+		copy(p, sampleRandom)
+		binary.BigEndian.PutUint32(p[16:20], msgLen)
+		copy(p[20:], "hello"+sampleAppID)
+		p[len(p)-1] = pad
+		return p
+	}
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{"填充为0", plaintext(5, 0)},
+		{"填充超过32", plaintext(5, 33)},
+		{"消息长度超出明文", plaintext(1000, 21)},
+		{"消息长度超过int32", plaintext(0xFFFFFFF0, 21)},
+	}
+	for _, tt := range tests {
+		ciphertext := make([]byte, len(tt.plaintext))
+		cipher.NewCBCEncrypter(c.block, c.key[:aes.BlockSize]).CryptBlocks(ciphertext, tt.plaintext)
+		encrypt := base64.StdEncoding.EncodeToString(ciphertext)
+		signature := c.Signature(sampleTimestamp, sampleNonce, encrypt)
+		if _, err := c.Decrypt(signature, sampleTimestamp, sampleNonce, encrypt); err == nil {
+			t.Errorf("%s: 应返回错误", tt.name)
+		}
+	}
+
+	for _, encrypt := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		signature := c.Signature(sampleTimestamp, sampleNonce, encrypt)
+		if _, err := c.Decrypt(signature, sampleTimestamp, sampleNonce, encrypt); err == nil {
+			t.Errorf("密文 %q 应返回错误", encrypt)
+		}
+	}
+}
+
+// TestNewMessageCrypterInvalidKey EncodingAESKey格式不正确时返回错误
+func TestNewMessageCrypterInvalidKey(t *testing.T) {
+	for _, key := range []string{"", sampleEncodingAESKey[:42], sampleEncodingAESKey[:42] + "!"} {
+		if _, err := NewMessageCrypter(sampleToken, key, sampleAppID); err == nil {
+			t.Errorf("EncodingAESKey %q 应返回错误", key)
+		}
+	}
+}
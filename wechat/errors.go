@@ -0,0 +1,141 @@
+package wechat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// 微信接口常见的错误码
+const (
+	ErrcodeSystemBusy          = -1    // 系统繁忙，此时请开发者稍候再试
+	ErrcodeInvalidCredential   = 40001 // 获取access_token时AppSecret错误，或者access_token无效
+	ErrcodeInvalidGrantType    = 40002 // 不合法的凭证类型
+	ErrcodeInvalidOpenID       = 40003 // 不合法的OpenID
+	ErrcodeInvalidAppID        = 40013 // 不合法的AppID
+	ErrcodeInvalidAccessToken  = 40014 // 不合法的access_token
+	ErrcodeInvalidOAuthCode    = 40029 // 不合法的oauth_code
+	ErrcodeInvalidCardCode     = 40056 // 不合法的卡券code
+	ErrcodeInvalidCardID       = 40073 // 不合法的卡券ID
+	ErrcodeCardCodeConsumed    = 40099 // 卡券code已被核销
+	ErrcodeInvalidAppSecret    = 40125 // 不合法的AppSecret
+	ErrcodeOAuthCodeUsed       = 40163 // oauth_code已使用
+	ErrcodeIPNotWhitelisted    = 40164 // 调用接口的IP地址不在白名单中
+	ErrcodeMissingAccessToken  = 41001 // 缺少access_token参数
+	ErrcodeAccessTokenExpired  = 42001 // access_token超时
+	ErrcodeRefreshTokenExpired = 42002 // refresh_token超时
+	ErrcodeQuotaExceeded       = 45009 // 接口调用超过每日限制
+	ErrcodeTooFrequent         = 45011 // API调用太频繁，请稍候再试
+	ErrcodeAPIUnauthorized     = 48001 // api功能未授权
+	ErrcodeUserUnauthorized    = 50001 // 用户未授权该api
+)
+
+// errcodeDescriptions 常见错误码的中文说明
+var errcodeDescriptions = map[int]string{
+	ErrcodeSystemBusy:          "系统繁忙，此时请开发者稍候再试",
+	ErrcodeInvalidCredential:   "获取access_token时AppSecret错误，或者access_token无效",
+	ErrcodeInvalidGrantType:    "不合法的凭证类型",
+	ErrcodeInvalidOpenID:       "不合法的OpenID",
+	ErrcodeInvalidAppID:        "不合法的AppID",
+	ErrcodeInvalidAccessToken:  "不合法的access_token",
+	ErrcodeInvalidOAuthCode:    "不合法的oauth_code",
+	ErrcodeInvalidCardCode:     "不合法的卡券code",
+	ErrcodeInvalidCardID:       "不合法的卡券ID",
+	ErrcodeCardCodeConsumed:    "卡券code已被核销",
+	ErrcodeInvalidAppSecret:    "不合法的AppSecret",
+	ErrcodeOAuthCodeUsed:       "oauth_code已使用",
+	ErrcodeIPNotWhitelisted:    "调用接口的IP地址不在白名单中",
+	ErrcodeMissingAccessToken:  "缺少access_token参数",
+	ErrcodeAccessTokenExpired:  "access_token超时",
+	ErrcodeRefreshTokenExpired: "refresh_token超时",
+	ErrcodeQuotaExceeded:       "接口调用超过每日限制",
+	ErrcodeTooFrequent:         "API调用太频繁，请稍候再试",
+	ErrcodeAPIUnauthorized:     "api功能未授权",
+	ErrcodeUserUnauthorized:    "用户未授权该api",
+}
+
+// ridPattern 从errmsg中提取rid，微信的errmsg形如 "invalid credential rid: 5f1a2b3c-1a2b3c4d-5e6f7a8b"
+var ridPattern = regexp.MustCompile(`rid:\s*(\S+)`)
+
+// APIError 微信接口返回的非0错误码
+type APIError struct {
+	Code     int    // 微信错误码 errcode
+	Msg      string // 微信错误信息 errmsg
+	Rid      string // 微信请求ID，可用于查询该次请求的详细信息
+	Endpoint string // 请求的接口路径，如 /card/get
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("微信接口 %s 返回错误 %d %s", e.Endpoint, e.Code, e.Msg)
+}
+
+// Description 返回错误码的中文说明，不在常见错误码中时返回errmsg
+func (e *APIError) Description() string {
+	if d, ok := errcodeDescriptions[e.Code]; ok {
+		return d
+	}
+	return e.Msg
+}
+
+// parseAPIError 从接口返回的json中解析错误码，errcode为0或无法解析时返回nil
+func parseAPIError(endpoint string, body []byte) *APIError {
+	result := struct {
+		Errcode int    `json:"errcode"`
+		Errmsg  string `json:"errmsg"`
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil || result.Errcode == 0 {
+		return nil
+	}
+	apiErr := &APIError{Code: result.Errcode, Msg: result.Errmsg, Endpoint: endpoint}
+	if m := ridPattern.FindStringSubmatch(result.Errmsg); m != nil {
+		apiErr.Rid = m[1]
+	}
+	return apiErr
+}
+
+// AsAPIError 判断err是否为(或包装了)微信接口返回的错误
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsTokenExpired 判断err是否表示AccessToken已过期或失效
+func IsTokenExpired(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && isTokenInvalid(apiErr.Code)
+}
+
+// IsQuotaExceeded 判断err是否表示接口调用超过每日限制，包括当天已返回过45009而不再请求的*QuotaLockedError
+func IsQuotaExceeded(err error) bool {
+	var locked *QuotaLockedError
+	if errors.As(err, &locked) {
+		return true
+	}
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.Code == ErrcodeQuotaExceeded
+}
+
+// IsTooFrequent 判断err是否表示API调用太频繁
+func IsTooFrequent(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.Code == ErrcodeTooFrequent
+}
+
+// IsSystemBusy 判断err是否表示微信系统繁忙
+func IsSystemBusy(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.Code == ErrcodeSystemBusy
+}
+
+// isTokenInvalid 错误码是否表示AccessToken已失效
+func isTokenInvalid(errcode int) bool {
+	switch errcode {
+	case ErrcodeInvalidCredential, ErrcodeInvalidAccessToken, ErrcodeAccessTokenExpired:
+		return true
+	}
+	return false
+}
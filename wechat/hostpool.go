@@ -0,0 +1,187 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	durationHostDown    = time.Second * 30 // 接口地址出错后暂停使用的时间，之后重新尝试
+	maxFailoverAttempts = 3                // 一次请求最多尝试的接口地址数
+)
+
+// defaultFailoverHosts 微信公布的api.weixin.qq.com的备用地址
+var defaultFailoverHosts = []string{
+	"api2.weixin.qq.com",
+	"sh.api.weixin.qq.com",
+	"sz.api.weixin.qq.com",
+	"hk.api.weixin.qq.com",
+}
+
+// hostPool 微信接口的主地址及备用地址，记录各地址的健康状况
+// 主地址出错时切换到备用地址，主地址暂停使用的时间过后自动切回主地址
+type hostPool struct {
+	mu    sync.Mutex
+	hosts []*poolHost // 第一个为主地址，其余按优先级排列
+}
+
+// poolHost 一个接口地址的健康状况
+type poolHost struct {
+	host      string
+	failures  int       // 连续出错的次数
+	downUntil time.Time // 在这个时间之前暂停使用
+}
+
+// newHostPool 创建以primary为主地址的hostPool
+func newHostPool(primary string, backups []string) *hostPool {
+	p := &hostPool{hosts: []*poolHost{{host: primary}}}
+	for _, host := range backups {
+		if host != primary {
+			p.hosts = append(p.hosts, &poolHost{host: host})
+		}
+	}
+	return p
+}
+
+// candidates 返回请求host时依次尝试的地址，host不是主地址时只尝试host本身
+// 健康的地址按优先级排在前面，暂停使用的地址排在后面
+func (p *hostPool) candidates(host string) []string {
+	if p == nil || len(p.hosts) < 2 || p.hosts[0].host != host {
+		return []string{host}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	healthy := make([]string, 0, len(p.hosts))
+	down := make([]string, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		if now.Before(h.downUntil) {
+			down = append(down, h.host)
+		} else {
+			healthy = append(healthy, h.host)
+		}
+	}
+	hosts := append(healthy, down...)
+	if len(hosts) > maxFailoverAttempts {
+		hosts = hosts[:maxFailoverAttempts]
+	}
+	return hosts
+}
+
+// markDown 记录地址出错，暂停使用一段时间
+func (p *hostPool) markDown(host string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		if h.host == host {
+			h.failures++
+			h.downUntil = time.Now().Add(durationHostDown)
+		}
+	}
+}
+
+// markUp 记录地址恢复正常
+func (p *hostPool) markUp(host string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		if h.host == host {
+			h.failures = 0
+			h.downUntil = time.Time{}
+		}
+	}
+}
+
+// roundTrip 发送请求，请求主地址时若遇到网络错误或5xx，切换到备用地址重新发送
+// 与重试相同，不能安全重发的请求（写接口、换取网页授权access_token等）只在连接阶段出错或返回502/503时切换，
+// 避免已被微信处理的请求被重复执行
+// 最多发送maxSends次，返回实际发送的次数，连接阶段出错的请求没有发送到服务器，不计算在内
+func (w *APIClient) roundTrip(ctx context.Context, req *http.Request, maxSends int) (*http.Response, int, error) {
+	hosts := w.hostPool.candidates(req.URL.Host)
+	sent := 0
+	for i, host := range hosts {
+		hostReq := req
+		if host != req.URL.Host || i > 0 {
+			var err error
+			if hostReq, err = withHost(req, host); err != nil {
+				return nil, sent, err
+			}
+		}
+
+		resp, err := w.client.Do(hostReq)
+		if err == nil || !isDialError(err) {
+			sent++
+		}
+		last := i == len(hosts)-1 || sent >= maxSends
+		if err != nil {
+			// If we got an error, and the context has been canceled,
+			// the context's error is probably more useful.
+			select {
+			case <-ctx.Done():
+				return nil, sent, ctx.Err()
+			default:
+			}
+
+			if isRetrySafe(ctx, req) || isDialError(err) {
+				w.hostPool.markDown(host)
+				if !last {
+					continue
+				}
+			}
+
+			// If the error type is *url.Error, sanitize its URL before returning.
+			if e, ok := err.(*url.Error); ok {
+				e.URL = redactURLString(e.URL)
+				return nil, sent, e
+			}
+			return nil, sent, err
+		}
+
+		if resp.StatusCode >= 500 && (isRetrySafe(ctx, req) || resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable) {
+			w.hostPool.markDown(host)
+			if !last {
+				io.CopyN(ioutil.Discard, resp.Body, 512)
+				resp.Body.Close()
+				continue
+			}
+			return resp, sent, nil
+		}
+		w.hostPool.markUp(host)
+		return resp, sent, nil
+	}
+	return nil, sent, errors.New("没有可用的接口地址")
+}
+
+// withHost 复制请求，并将请求地址的host替换为host
+func withHost(req *http.Request, host string) (*http.Request, error) {
+	hostReq, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	hostReq.URL.Host = host
+	hostReq.Host = ""
+	return hostReq, nil
+}
+
+// isDialError 判断是否为建立连接阶段的错误，此时请求一定还没有发送到服务器
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
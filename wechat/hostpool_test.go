@@ -0,0 +1,118 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFailoverDroppedConnection 主地址断开连接时，可以安全重发的GET切换到备用地址，
+// 换取网页授权access_token的code只能使用一次，不切换也不重试
+func TestFailoverDroppedConnection(t *testing.T) {
+	tests := []struct {
+		path         string
+		wantFailover bool
+	}{
+		{"cgi-bin/get_api_domain_ip", true},
+		{"sns/oauth2/access_token?appid=APPID&secret=SECRET&code=CODE&grant_type=authorization_code", false},
+	}
+	for _, tt := range tests {
+		var primaryCalls, backupCalls int32
+		primary := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&primaryCalls, 1)
+			dropConnection(rw)
+		})
+		backup := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&backupCalls, 1)
+			fmt.Fprint(rw, `{"errcode":0}`)
+		})
+		w := newTestClient(t, primary.URL, WithFailoverHosts(testHost(t, backup.URL)))
+
+		req, err := w.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Do(context.Background(), req, nil)
+		if tt.wantFailover && err != nil {
+			t.Fatalf("%s 应切换到备用地址，实际返回 %v", tt.path, err)
+		}
+		if !tt.wantFailover && err == nil {
+			t.Fatalf("%s 不应切换到备用地址", tt.path)
+		}
+		if n := atomic.LoadInt32(&primaryCalls); n != 1 {
+			t.Fatalf("%s 请求主地址%d次，应为1次", tt.path, n)
+		}
+		wantBackup := int32(0)
+		if tt.wantFailover {
+			wantBackup = 1
+		}
+		if n := atomic.LoadInt32(&backupCalls); n != wantBackup {
+			t.Fatalf("%s 请求备用地址%d次，应为%d次", tt.path, n, wantBackup)
+		}
+	}
+}
+
+// TestFailoverPrimaryRecovers 主地址出错后暂停使用，durationHostDown过后重新使用主地址
+func TestFailoverPrimaryRecovers(t *testing.T) {
+	var healthy, primaryCalls, backupCalls int32
+	primary := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(rw, `{"errcode":0}`)
+	})
+	backup := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupCalls, 1)
+		fmt.Fprint(rw, `{"errcode":0}`)
+	})
+	w := newTestClient(t, primary.URL, WithFailoverHosts(testHost(t, backup.URL)))
+	do := func() {
+		t.Helper()
+		req, err := w.NewRequest("GET", "cgi-bin/get_api_domain_ip", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Do(context.Background(), req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertCalls := func(wantPrimary, wantBackup int32) {
+		t.Helper()
+		if p, b := atomic.LoadInt32(&primaryCalls), atomic.LoadInt32(&backupCalls); p != wantPrimary || b != wantBackup {
+			t.Fatalf("主地址、备用地址分别被请求%d、%d次，应为%d、%d次", p, b, wantPrimary, wantBackup)
+		}
+	}
+
+	do()
+	assertCalls(1, 1)
+
+	// 主地址已恢复，但仍在暂停使用的时间内
+	atomic.StoreInt32(&healthy, 1)
+	do()
+	assertCalls(1, 2)
+
+	// 模拟durationHostDown已过去
+	w.hostPool.mu.Lock()
+	w.hostPool.hosts[0].downUntil = time.Now().Add(-time.Second)
+	w.hostPool.mu.Unlock()
+	do()
+	assertCalls(2, 2)
+	do()
+	assertCalls(3, 2)
+}
+
+// testHost 返回rawURL中的host
+func testHost(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
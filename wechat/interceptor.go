@@ -0,0 +1,82 @@
+package wechat
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// CallInfo 一次微信接口请求的信息，Handler返回后StatusCode、Errcode、Duration才有值
+type CallInfo struct {
+	Endpoint   string        // 请求的接口路径，如 /card/get
+	Method     string        // HTTP方法
+	Attempt    int           // 本次调用中第几次发送请求，从1开始
+	StatusCode int           // HTTP状态码，请求没有发出或没有收到返回时为0
+	Errcode    int           // 微信返回的errcode，成功或返回数据不是json时为0
+	Duration   time.Duration // 发送请求到读取完返回数据的耗时
+}
+
+// Handler 发送一次接口请求，并把结果记录到info
+// 微信返回非0的errcode时返回*APIError
+type Handler func(ctx context.Context, req *http.Request, info *CallInfo) error
+
+// Interceptor 包装Handler，在每次发送请求前后执行，可用于指标统计、链路追踪等
+// Interceptor可以修改ctx和req后再调用next，也可以不调用next直接返回错误
+type Interceptor func(next Handler) Handler
+
+// chain 用interceptors包装h，第一个Interceptor在最外层
+func chain(h Handler, interceptors []Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		h = interceptors[i](h)
+	}
+	return h
+}
+
+// MetricsCollector 接口调用指标的收集器，可以用prometheus、statsd等实现
+type MetricsCollector interface {
+	ObserveCall(info CallInfo, err error)
+}
+
+// MetricsInterceptor 每次发送请求后把CallInfo交给collector
+func MetricsInterceptor(collector MetricsCollector) Interceptor {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *http.Request, info *CallInfo) error {
+			err := next(ctx, req, info)
+			collector.ObserveCall(*info, err)
+			return err
+		}
+	}
+}
+
+// Tracer 创建链路追踪的Span，可以用OpenTelemetry等实现
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 一次接口请求的追踪记录
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// TracingInterceptor 为每次发送请求创建一个名为 "wechat <Endpoint>" 的Span
+func TracingInterceptor(tracer Tracer) Interceptor {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *http.Request, info *CallInfo) error {
+			ctx, span := tracer.StartSpan(ctx, "wechat "+info.Endpoint)
+			defer span.End()
+			span.SetAttribute("wechat.endpoint", info.Endpoint)
+			span.SetAttribute("wechat.attempt", info.Attempt)
+			span.SetAttribute("http.method", info.Method)
+
+			err := next(ctx, req, info)
+			span.SetAttribute("http.status_code", info.StatusCode)
+			span.SetAttribute("wechat.errcode", info.Errcode)
+			if err != nil {
+				span.RecordError(err)
+			}
+			return err
+		}
+	}
+}
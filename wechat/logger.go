@@ -0,0 +1,162 @@
+package wechat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// 日志级别，用于APIConfig.LogLevel
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+const redacted = "***"
+
+// Logger 结构化日志接口，方法与*slog.Logger一致，可以直接使用slog.Default()
+// args为交替出现的key和value
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// logLevels 日志级别的顺序
+var logLevels = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelWarn:  2,
+	LogLevelError: 3,
+}
+
+// stdLogger 未设置Logger时使用的默认日志，以标准库log输出不低于level的日志
+type stdLogger struct {
+	level int
+}
+
+// newStdLogger 创建输出不低于level的默认日志，level为空时为info
+func newStdLogger(level string) *stdLogger {
+	l, ok := logLevels[level]
+	if !ok {
+		l = logLevels[LogLevelInfo]
+	}
+	return &stdLogger{level: l}
+}
+
+func (l *stdLogger) Debug(msg string, args ...interface{}) { l.output(LogLevelDebug, msg, args) }
+func (l *stdLogger) Info(msg string, args ...interface{})  { l.output(LogLevelInfo, msg, args) }
+func (l *stdLogger) Warn(msg string, args ...interface{})  { l.output(LogLevelWarn, msg, args) }
+func (l *stdLogger) Error(msg string, args ...interface{}) { l.output(LogLevelError, msg, args) }
+
+func (l *stdLogger) output(level, msg string, args []interface{}) {
+	if logLevels[level] < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	log.Print(b.String())
+}
+
+// sensitiveParams 日志中需要隐藏的url参数
+var sensitiveParams = []string{"access_token", "secret", "appsecret", "code", "refresh_token", "ticket"}
+
+// sensitiveFields 日志中需要隐藏的json字段，包括凭证及CardMemberInfo、WXUserInfo中的个人信息
+var sensitiveFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"secret":        true,
+	"appsecret":     true,
+	"ticket":        true,
+	"encrypt_code":  true,
+	"nickname":      true,
+	"headimgurl":    true,
+	"remark":        true,
+	"sex":           true,
+	"city":          true,
+	"province":      true,
+	"country":       true,
+	"mobile":        true,
+	"real_name":     true,
+	"birthday":      true,
+}
+
+// redactURL 返回隐藏了凭证参数的url
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	query := u.Query()
+	changed := false
+	for _, p := range sensitiveParams {
+		if _, ok := query[p]; ok {
+			query.Set(p, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = query.Encode()
+	return c.String()
+}
+
+// redactURLString 返回隐藏了凭证参数的url，无法解析时整个隐藏
+func redactURLString(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	return redactURL(u)
+}
+
+// redactBody 返回隐藏了凭证和个人信息的json，会员卡的 {"name":...,"value":...} 字段一律隐藏value
+// 不是json时只输出长度
+func redactBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	data, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	return string(data)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		_, hasName := t["name"]
+		_, hasValue := t["value"]
+		for k, item := range t {
+			if sensitiveFields[strings.ToLower(k)] || (hasName && hasValue && k == "value") {
+				t[k] = redacted
+			} else {
+				t[k] = redactValue(item)
+			}
+		}
+		return t
+	case []interface{}:
+		for i, item := range t {
+			t[i] = redactValue(item)
+		}
+		return t
+	}
+	return v
+}
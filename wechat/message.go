@@ -0,0 +1,290 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// 消息类型 MsgType
+const (
+	MsgTypeText       = "text"       // 文本消息
+	MsgTypeImage      = "image"      // 图片消息
+	MsgTypeVoice      = "voice"      // 语音消息
+	MsgTypeVideo      = "video"      // 视频消息
+	MsgTypeShortVideo = "shortvideo" // 小视频消息
+	MsgTypeLocation   = "location"   // 地理位置消息
+	MsgTypeLink       = "link"       // 链接消息
+	MsgTypeEvent      = "event"      // 事件推送，具体事件见Event
+)
+
+// 事件类型 Event
+const (
+	EventSubscribe         = "subscribe"          // 关注，扫描带参数二维码关注时EventKey为qrscene_开头的场景值
+	EventUnsubscribe       = "unsubscribe"        // 取消关注
+	EventScan              = "SCAN"               // 已关注用户扫描带参数二维码
+	EventLocation          = "LOCATION"           // 上报地理位置
+	EventClick             = "CLICK"              // 点击菜单拉取消息
+	EventView              = "VIEW"               // 点击菜单跳转链接
+	EventScancodePush      = "scancode_push"      // 扫码推事件
+	EventScancodeWaitmsg   = "scancode_waitmsg"   // 扫码推事件且弹出"消息接收中"提示框
+	EventPicSysPhoto       = "pic_sysphoto"       // 弹出系统拍照发图
+	EventPicPhotoOrAlbum   = "pic_photo_or_album" // 弹出拍照或者相册发图
+	EventPicWeixin         = "pic_weixin"         // 弹出微信相册发图器
+	EventLocationSelect    = "location_select"    // 弹出地理位置选择器
+	qrScenePrefix          = "qrscene_"           // 扫描带参数二维码关注时EventKey的前缀
+	messageTypeEventPrefix = "event:"             // messageTypes中事件的key前缀
+)
+
+// TypedMessage DecodeMessage返回的消息或事件，都可以通过Header获取公共字段
+type TypedMessage interface {
+	Header() *MessageHeader
+}
+
+// MessageHeader 所有消息和事件共有的字段
+type MessageHeader struct {
+	ToUserName   string `xml:"ToUserName"`   // 开发者微信号，即公众号原始ID
+	FromUserName string `xml:"FromUserName"` // 发送方帐号，即用户的openid
+	CreateTime   int64  `xml:"CreateTime"`   // 消息创建时间，unix时间戳
+	MsgType      string `xml:"MsgType"`      // 消息类型
+}
+
+// Header 返回公共字段
+func (h *MessageHeader) Header() *MessageHeader {
+	return h
+}
+
+// EventHeader 所有事件共有的字段
+type EventHeader struct {
+	MessageHeader
+	Event string `xml:"Event"` // 事件类型
+}
+
+// Message 微信推送的消息或事件的公共字段，其他字段可以用Context.Decode解析
+// 也是DecodeMessage遇到未知类型时的返回值
+type Message struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	Event string `xml:"Event"` // 事件类型，MsgType为event时有值
+	MsgID int64  `xml:"MsgId"` // 消息id，事件推送没有
+	Raw   []byte `xml:"-"`     // 消息的原始XML
+}
+
+// TextMessage 文本消息
+type TextMessage struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	Content string `xml:"Content"` // 文本消息内容
+	MsgID   int64  `xml:"MsgId"`   // 消息id
+}
+
+// ImageMessage 图片消息
+type ImageMessage struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	PicURL  string `xml:"PicUrl"`  // 图片链接
+	MediaID string `xml:"MediaId"` // 图片消息媒体id，可以调用获取临时素材接口拉取数据
+	MsgID   int64  `xml:"MsgId"`   // 消息id
+}
+
+// VoiceMessage 语音消息
+type VoiceMessage struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	MediaID     string `xml:"MediaId"`     // 语音消息媒体id
+	Format      string `xml:"Format"`      // 语音格式，如amr、speex
+	Recognition string `xml:"Recognition"` // 语音识别结果，开通语音识别后才有
+	MsgID       int64  `xml:"MsgId"`       // 消息id
+}
+
+// VideoMessage 视频消息，MsgType为video或shortvideo
+type VideoMessage struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	MediaID      string `xml:"MediaId"`      // 视频消息媒体id
+	ThumbMediaID string `xml:"ThumbMediaId"` // 视频消息缩略图的媒体id
+	MsgID        int64  `xml:"MsgId"`        // 消息id
+}
+
+// LocationMessage 地理位置消息
+type LocationMessage struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	LocationX float64 `xml:"Location_X"` // 纬度
+	LocationY float64 `xml:"Location_Y"` // 经度
+	Scale     int     `xml:"Scale"`      // 地图缩放大小
+	Label     string  `xml:"Label"`      // 地理位置信息
+	MsgID     int64   `xml:"MsgId"`      // 消息id
+}
+
+// LinkMessage 链接消息
+type LinkMessage struct {
+	XMLName xml.Name `xml:"xml"`
+	MessageHeader
+	Title       string `xml:"Title"`       // 消息标题
+	Description string `xml:"Description"` // 消息描述
+	URL         string `xml:"Url"`         // 消息链接
+	MsgID       int64  `xml:"MsgId"`       // 消息id
+}
+
+// SubscribeEvent 关注事件，扫描带参数二维码关注时带有EventKey和Ticket
+type SubscribeEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，qrscene_为前缀，后面为二维码的参数值
+	Ticket   string `xml:"Ticket"`   // 二维码的ticket，可用来换取二维码图片
+}
+
+// QRScene 返回去掉qrscene_前缀的二维码参数值，不是扫描带参数二维码关注时ok为false
+func (e *SubscribeEvent) QRScene() (scene string, ok bool) {
+	if !strings.HasPrefix(e.EventKey, qrScenePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(e.EventKey, qrScenePrefix), true
+}
+
+// UnsubscribeEvent 取消关注事件
+type UnsubscribeEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+}
+
+// ScanEvent 已关注用户扫描带参数二维码事件
+type ScanEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，即创建二维码时的二维码scene_id或scene_str
+	Ticket   string `xml:"Ticket"`   // 二维码的ticket，可用来换取二维码图片
+}
+
+// QRScene 返回二维码参数值，与SubscribeEvent.QRScene一致，ok总为true
+func (e *ScanEvent) QRScene() (scene string, ok bool) {
+	return e.EventKey, true
+}
+
+// LocationEvent 上报地理位置事件
+type LocationEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	Latitude  float64 `xml:"Latitude"`  // 地理位置纬度
+	Longitude float64 `xml:"Longitude"` // 地理位置经度
+	Precision float64 `xml:"Precision"` // 地理位置精度
+}
+
+// ClickEvent 点击菜单拉取消息事件
+type ClickEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，与自定义菜单接口中KEY值对应
+}
+
+// ViewEvent 点击菜单跳转链接事件
+type ViewEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey string `xml:"EventKey"` // 事件KEY值，设置的跳转URL
+	MenuID   string `xml:"MenuId"`   // 个性化菜单的菜单id
+}
+
+// ScanCodeInfo 扫码事件的扫描信息
+type ScanCodeInfo struct {
+	ScanType   string `xml:"ScanType"`   // 扫描类型，一般是qrcode
+	ScanResult string `xml:"ScanResult"` // 扫描结果，即二维码对应的字符串信息
+}
+
+// ScancodeEvent 扫码推事件，Event为scancode_push或scancode_waitmsg
+type ScancodeEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey     string       `xml:"EventKey"`     // 事件KEY值，由开发者在创建菜单时设定
+	ScanCodeInfo ScanCodeInfo `xml:"ScanCodeInfo"` // 扫描信息
+}
+
+// SendPicsInfo 发图事件的图片信息
+type SendPicsInfo struct {
+	Count   int `xml:"Count"` // 发送的图片数量
+	PicList []struct {
+		PicMd5Sum string `xml:"PicMd5Sum"` // 图片的MD5值
+	} `xml:"PicList>item"` // 图片列表
+}
+
+// PicEvent 发图事件，Event为pic_sysphoto、pic_photo_or_album或pic_weixin
+type PicEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey     string       `xml:"EventKey"`     // 事件KEY值，由开发者在创建菜单时设定
+	SendPicsInfo SendPicsInfo `xml:"SendPicsInfo"` // 发送的图片信息
+}
+
+// SendLocationInfo 地理位置选择事件的位置信息
+type SendLocationInfo struct {
+	LocationX float64 `xml:"Location_X"` // 纬度
+	LocationY float64 `xml:"Location_Y"` // 经度
+	Scale     int     `xml:"Scale"`      // 精度，可理解为精度或者比例尺
+	Label     string  `xml:"Label"`      // 地理位置的字符串信息
+	Poiname   string  `xml:"Poiname"`    // 朋友圈POI的名字
+}
+
+// LocationSelectEvent 弹出地理位置选择器事件
+type LocationSelectEvent struct {
+	XMLName xml.Name `xml:"xml"`
+	EventHeader
+	EventKey         string           `xml:"EventKey"`         // 事件KEY值，由开发者在创建菜单时设定
+	SendLocationInfo SendLocationInfo `xml:"SendLocationInfo"` // 发送的位置信息
+}
+
+// messageTypes 消息类型和事件对应的结构体，事件的key为 event:小写的Event
+var messageTypes = map[string]func() TypedMessage{
+	MsgTypeText:       func() TypedMessage { return &TextMessage{} },
+	MsgTypeImage:      func() TypedMessage { return &ImageMessage{} },
+	MsgTypeVoice:      func() TypedMessage { return &VoiceMessage{} },
+	MsgTypeVideo:      func() TypedMessage { return &VideoMessage{} },
+	MsgTypeShortVideo: func() TypedMessage { return &VideoMessage{} },
+	MsgTypeLocation:   func() TypedMessage { return &LocationMessage{} },
+	MsgTypeLink:       func() TypedMessage { return &LinkMessage{} },
+
+	eventTypeKey(EventSubscribe):       func() TypedMessage { return &SubscribeEvent{} },
+	eventTypeKey(EventUnsubscribe):     func() TypedMessage { return &UnsubscribeEvent{} },
+	eventTypeKey(EventScan):            func() TypedMessage { return &ScanEvent{} },
+	eventTypeKey(EventLocation):        func() TypedMessage { return &LocationEvent{} },
+	eventTypeKey(EventClick):           func() TypedMessage { return &ClickEvent{} },
+	eventTypeKey(EventView):            func() TypedMessage { return &ViewEvent{} },
+	eventTypeKey(EventScancodePush):    func() TypedMessage { return &ScancodeEvent{} },
+	eventTypeKey(EventScancodeWaitmsg): func() TypedMessage { return &ScancodeEvent{} },
+	eventTypeKey(EventPicSysPhoto):     func() TypedMessage { return &PicEvent{} },
+	eventTypeKey(EventPicPhotoOrAlbum): func() TypedMessage { return &PicEvent{} },
+	eventTypeKey(EventPicWeixin):       func() TypedMessage { return &PicEvent{} },
+	eventTypeKey(EventLocationSelect):  func() TypedMessage { return &LocationSelectEvent{} },
+}
+
+// eventTypeKey 事件在messageTypes中的key
+func eventTypeKey(event string) string {
+	return messageTypeEventPrefix + strings.ToLower(event)
+}
+
+// DecodeMessage 根据MsgType和Event把推送的XML解析为对应的结构体指针，如*TextMessage、*SubscribeEvent
+// 未知的消息类型和事件返回*Message
+func DecodeMessage(data []byte) (TypedMessage, error) {
+	msg := &Message{}
+	if err := xml.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	msg.Raw = data
+	return decodeTypedMessage(msg)
+}
+
+// decodeTypedMessage 按已解析的公共字段选择结构体，解析msg.Raw
+func decodeTypedMessage(msg *Message) (TypedMessage, error) {
+	key := msg.MsgType
+	if msg.MsgType == MsgTypeEvent {
+		key = eventTypeKey(msg.Event)
+	}
+	newMessage, ok := messageTypes[key]
+	if !ok {
+		return msg, nil
+	}
+	typed := newMessage()
+	if err := xml.Unmarshal(msg.Raw, typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
@@ -1,15 +1,16 @@
 package wechat
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
 )
 
 const (
-	urlGetOAuthAccessToken = "https://api.weixin.qq.com/sns/oauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code"
-	urlGetOAuthUserInfo    = "https://api.weixin.qq.com/sns/userinfo?access_token=%s&openid=%s&lang=zh_CN"
-	urlOAuthPage           = "https://open.weixin.qq.com/connect/oauth2/authorize?appid=%s&redirect_uri=%s&response_type=code&scope=snsapi_userinfo#wechat_redirect"
+	urlGetOAuthAccessToken = "sns/oauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code"
+	urlGetOAuthUserInfo    = "sns/userinfo?access_token=%s&openid=%s&lang=zh_CN"
+	urlOAuthPage           = "connect/oauth2/authorize?appid=%s&redirect_uri=%s&response_type=code&scope=snsapi_userinfo#wechat_redirect"
 )
 
 // OAuthUser 通过微信网页授权拉取的用户信息
@@ -42,6 +43,11 @@ type oauthAccessToken struct {
 
 // GetUserByCode 直接通过code获取OAuthUser
 func (s *OAuthService) GetUserByCode(code string) (*OAuthUser, error) {
+	return s.GetUserByCodeContext(context.Background(), code)
+}
+
+// GetUserByCodeContext 与GetUserByCode相同，通过ctx控制请求的超时与取消
+func (s *OAuthService) GetUserByCodeContext(ctx context.Context, code string) (*OAuthUser, error) {
 	// 第一步，用code从微信服务器换取access_token
 	url := fmt.Sprintf(urlGetOAuthAccessToken, s.wechat.AppID, s.wechat.AppSecret, code)
 	req, err := s.wechat.NewRequest("GET", url, nil)
@@ -49,7 +55,7 @@ func (s *OAuthService) GetUserByCode(code string) (*OAuthUser, error) {
 		return nil, err
 	}
 	token := &oauthAccessToken{}
-	_, err = s.wechat.Do(nil, req, token)
+	_, err = s.wechat.Do(ctx, req, token)
 	if err != nil {
 		return nil, err
 	}
@@ -58,18 +64,23 @@ func (s *OAuthService) GetUserByCode(code string) (*OAuthUser, error) {
 	}
 
 	// 第二步，用access_token去拉取用户信息
-	return s.GetUserByAccessToken(token.AccessToken, token.Openid)
+	return s.GetUserByAccessTokenContext(ctx, token.AccessToken, token.Openid)
 }
 
 // GetUserByAccessToken 当scope为snsapi_userinfo时，通过access_token和openid拉取用户信息
 func (s *OAuthService) GetUserByAccessToken(accessToken, openID string) (*OAuthUser, error) {
+	return s.GetUserByAccessTokenContext(context.Background(), accessToken, openID)
+}
+
+// GetUserByAccessTokenContext 与GetUserByAccessToken相同，通过ctx控制请求的超时与取消
+func (s *OAuthService) GetUserByAccessTokenContext(ctx context.Context, accessToken, openID string) (*OAuthUser, error) {
 	url := fmt.Sprintf(urlGetOAuthUserInfo, accessToken, openID)
 	req, err := s.wechat.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	oauthUser := &OAuthUser{}
-	_, err = s.wechat.Do(nil, req, oauthUser)
+	_, err = s.wechat.Do(ctx, req, oauthUser)
 	if err != nil {
 		return nil, err
 	}
@@ -83,5 +94,5 @@ func (s *OAuthService) GetUserByAccessToken(accessToken, openID string) (*OAuthU
 func (s *OAuthService) Link(landingPage, from string) string {
 	// 将from转换为landingPage?from={from}的地址
 	redirectURL := url.QueryEscape(fmt.Sprintf("%s?from=%s", landingPage, from))
-	return fmt.Sprintf(urlOAuthPage, s.wechat.AppID, redirectURL)
+	return s.wechat.OpenBaseURL.String() + fmt.Sprintf(urlOAuthPage, s.wechat.AppID, redirectURL)
 }
@@ -0,0 +1,203 @@
+package wechat
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gotit/errors"
+)
+
+// Option 创建APIClient时的可选配置
+type Option func(w *APIClient) error
+
+// WithHTTPClient 使用自定义的http.Client调用接口
+func WithHTTPClient(client *http.Client) Option {
+	return func(w *APIClient) error {
+		if client == nil {
+			return errors.New("http.Client不能为空")
+		}
+		w.client = client
+		return nil
+	}
+}
+
+// WithTransport 使用自定义的http.RoundTripper调用接口，可用于mTLS、连接池设置等
+func WithTransport(transport http.RoundTripper) Option {
+	return func(w *APIClient) error {
+		if transport == nil {
+			return errors.New("http.RoundTripper不能为空")
+		}
+		client := *w.client
+		client.Transport = transport
+		w.client = &client
+		return nil
+	}
+}
+
+// WithProxy 通过出口代理调用接口，proxyURL形如 http://proxy.internal:3128
+// 当前http.Client的Transport必须是*http.Transport
+func WithProxy(proxyURL string) Option {
+	return func(w *APIClient) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return errors.Errorf("代理地址无效 %s", err.Error())
+		}
+		var transport *http.Transport
+		switch t := w.client.Transport.(type) {
+		case nil:
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			transport = t.Clone()
+		default:
+			return errors.New("只有*http.Transport支持设置代理")
+		}
+		transport.Proxy = http.ProxyURL(u)
+		client := *w.client
+		client.Transport = transport
+		w.client = &client
+		return nil
+	}
+}
+
+// WithTimeout 设置每次调用接口的超时时间，0为不限制，默认30秒
+func WithTimeout(timeout time.Duration) Option {
+	return func(w *APIClient) error {
+		if timeout < 0 {
+			return errors.New("超时时间不能为负数")
+		}
+		w.timeout = timeout
+		return nil
+	}
+}
+
+// WithBaseURL 设置微信公众平台接口地址，默认为 https://api.weixin.qq.com/
+func WithBaseURL(baseURL string) Option {
+	return func(w *APIClient) (err error) {
+		w.BaseURL, err = parseBaseURL(baseURL)
+		return
+	}
+}
+
+// WithFailoverHosts 设置BaseURL的备用地址，如 api2.weixin.qq.com
+// BaseURL出现网络错误或5xx时切换到备用地址，恢复后切回BaseURL，切换后重新发送的次数计入RetryPolicy.MaxAttempts
+// 使用默认BaseURL时默认使用微信公布的备用地址，不传hosts则关闭切换
+func WithFailoverHosts(hosts ...string) Option {
+	return func(w *APIClient) error {
+		w.failoverHosts = append([]string{}, hosts...)
+		return nil
+	}
+}
+
+// WithMchBaseURL 设置微信商户平台接口地址，默认为 https://api.mch.weixin.qq.com/
+func WithMchBaseURL(baseURL string) Option {
+	return func(w *APIClient) (err error) {
+		w.MchBaseURL, err = parseBaseURL(baseURL)
+		return
+	}
+}
+
+// WithOpenBaseURL 设置微信网页授权页面地址，默认为 https://open.weixin.qq.com/
+func WithOpenBaseURL(baseURL string) Option {
+	return func(w *APIClient) (err error) {
+		w.OpenBaseURL, err = parseBaseURL(baseURL)
+		return
+	}
+}
+
+// parseBaseURL 解析接口地址，保证以/结尾，使相对路径能正确拼接
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.Errorf("接口地址无效 %s", err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("接口地址 %s 必须以http://或https://开头", baseURL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u, nil
+}
+
+// WithRetryPolicy 设置临时故障的重试策略，默认为DefaultRetryPolicy()，NoRetryPolicy()关闭重试
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(w *APIClient) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		policy.RetryableErrcodes = append([]int{}, policy.RetryableErrcodes...)
+		w.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithRateLimits 设置按接口分组的令牌桶限流，一个接口只使用第一个匹配的分组，不属于任何分组的接口不限流
+// 可以多次使用，按顺序追加
+func WithRateLimits(limits ...RateLimit) Option {
+	return func(w *APIClient) error {
+		for _, limit := range limits {
+			if err := limit.validate(); err != nil {
+				return err
+			}
+			limit.Endpoints = append([]string{}, limit.Endpoints...)
+			w.buckets = append(w.buckets, newTokenBucket(limit))
+		}
+		return nil
+	}
+}
+
+// WithDailyQuotas 设置或补充接口的每日调用次数上限，如 {"/card/get": 100000}，用于本地统计和告警
+func WithDailyQuotas(quotas map[string]int) Option {
+	return func(w *APIClient) error {
+		for endpoint, limit := range quotas {
+			if limit < 0 {
+				return errors.Errorf("接口 %s 的每日调用次数上限不能为负数", endpoint)
+			}
+			w.quota.limits[endpoint] = limit
+		}
+		return nil
+	}
+}
+
+// WithCircuitBreaker 设置按接口熔断的配置，默认为DefaultCircuitBreakerConfig()，FailureThreshold不大于0时关闭熔断
+func WithCircuitBreaker(config CircuitBreakerConfig) Option {
+	return func(w *APIClient) error {
+		if err := config.validate(); err != nil {
+			return err
+		}
+		if config.FailureThreshold > 0 {
+			w.breaker = newCircuitBreaker(config)
+		} else {
+			w.breaker = nil
+		}
+		return nil
+	}
+}
+
+// WithDebugCapture 设置debug日志最多输出的返回数据字节数，默认4096，0为不输出返回数据
+// 返回数据超过limit时只输出长度，返回数据会隐藏凭证和个人信息后输出
+func WithDebugCapture(limit int) Option {
+	return func(w *APIClient) error {
+		if limit < 0 {
+			return errors.New("debug日志输出的字节数不能为负数")
+		}
+		w.debugCapture = limit
+		return nil
+	}
+}
+
+// WithInterceptors 在每次发送接口请求前后执行interceptors，第一个Interceptor在最外层
+// 可以多次使用，按顺序追加
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(w *APIClient) error {
+		for _, interceptor := range interceptors {
+			if interceptor == nil {
+				return errors.New("Interceptor不能为空")
+			}
+		}
+		w.interceptors = append(w.interceptors, interceptors...)
+		return nil
+	}
+}
@@ -4,11 +4,11 @@ package wechat
 type PayService service
 
 const (
-	// 支付URL
+	// 支付URL，jsapiTicket相对于BaseURL，其他相对于MchBaseURL
 
-	urlJsapiTicket = "https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=%s&type=jsapi" // 获取jsapiTicket url
-	urlUnifyOrder  = "https://api.mch.weixin.qq.com/pay/unifiedorder"                                // 微信统一下单接口
-	urlOrderQuery  = "https://api.mch.weixin.qq.com/pay/orderquery"                                  // 微信订单查询接口
+	urlJsapiTicket = "cgi-bin/ticket/getticket?access_token=%s&type=jsapi" // 获取jsapiTicket url
+	urlUnifyOrder  = "pay/unifiedorder"                                    // 微信统一下单接口
+	urlOrderQuery  = "pay/orderquery"                                      // 微信订单查询接口
 
 	// 支付类型
 
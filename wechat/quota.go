@@ -0,0 +1,116 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// QuotaService 接口调用次数的查询、清零及rid查询服务
+type QuotaService service
+
+const (
+	urlGetQuota   = "cgi-bin/openapi/quota/get?access_token=%s" // 查询接口每日调用次数
+	urlClearQuota = "cgi-bin/clear_quota?access_token=%s"       // 清空所有接口的调用次数，每月共10次
+	urlGetRid     = "cgi-bin/openapi/rid/get?access_token=%s"   // 查询rid对应的请求信息
+)
+
+// Quota 微信统计的接口当天调用次数
+type Quota struct {
+	DailyLimit int64 `json:"daily_limit"` // 当天该账号可调用该接口的次数
+	Used       int64 `json:"used"`        // 当天已经调用的次数
+	Remain     int64 `json:"remain"`      // 当天剩余调用次数
+}
+
+// RidInfo rid对应的请求信息
+type RidInfo struct {
+	InvokeTime   int64  `json:"invoke_time"`   // 发起请求的时间戳
+	CostInMs     int64  `json:"cost_in_ms"`    // 请求毫秒级耗时
+	RequestURL   string `json:"request_url"`   // 请求的URL参数
+	RequestBody  string `json:"request_body"`  // post请求的请求参数
+	ResponseBody string `json:"response_body"` // 接口请求返回参数
+	ClientIP     string `json:"client_ip"`     // 接口请求的客户端ip
+}
+
+// Get 查询接口当天的调用次数，cgiPath为接口路径，如 /card/get
+func (s *QuotaService) Get(cgiPath string) (*Quota, error) {
+	return s.GetContext(context.Background(), cgiPath)
+}
+
+// GetContext 与Get相同，通过ctx控制请求的超时与取消
+func (s *QuotaService) GetContext(ctx context.Context, cgiPath string) (*Quota, error) {
+	token, err := s.wechat.GetAccessTokenContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf(urlGetQuota, token)
+	req, err := s.wechat.NewRequest("POST", url, map[string]interface{}{"cgi_path": cgiPath})
+	if err != nil {
+		return nil, err
+	}
+	result := &struct {
+		Quota Quota `json:"quota"`
+	}{}
+	_, err = s.wechat.Do(ctx, req, result)
+	if err != nil {
+		return nil, err
+	}
+	return &result.Quota, nil
+}
+
+// Clear 清空公众号所有接口当天的调用次数，微信限制每个账号每月共10次
+// 成功后同时清空本地的调用次数统计及45009的锁定
+func (s *QuotaService) Clear() error {
+	return s.ClearContext(context.Background())
+}
+
+// ClearContext 与Clear相同，通过ctx控制请求的超时与取消
+func (s *QuotaService) ClearContext(ctx context.Context) error {
+	token, err := s.wechat.GetAccessTokenContext(ctx)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf(urlClearQuota, token)
+	req, err := s.wechat.NewRequest("POST", url, map[string]interface{}{"appid": s.wechat.AppID})
+	if err != nil {
+		return err
+	}
+	_, err = s.wechat.Do(ctx, req, nil)
+	if err != nil {
+		return err
+	}
+	s.wechat.quota.reset()
+	return nil
+}
+
+// GetRid 查询rid对应的请求信息，rid见APIError.Rid，只能查询7天内的请求
+func (s *QuotaService) GetRid(rid string) (*RidInfo, error) {
+	return s.GetRidContext(context.Background(), rid)
+}
+
+// GetRidContext 与GetRid相同，通过ctx控制请求的超时与取消
+func (s *QuotaService) GetRidContext(ctx context.Context, rid string) (*RidInfo, error) {
+	token, err := s.wechat.GetAccessTokenContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf(urlGetRid, token)
+	req, err := s.wechat.NewRequest("POST", url, map[string]interface{}{"rid": rid})
+	if err != nil {
+		return nil, err
+	}
+	result := &struct {
+		Request RidInfo `json:"request"`
+	}{}
+	_, err = s.wechat.Do(ctx, req, result)
+	if err != nil {
+		return nil, err
+	}
+	return &result.Request, nil
+}
+
+// Usage 返回本进程统计的接口当天调用次数，以及因45009被锁定的接口
+// 多个进程共用一个公众号时，实际调用次数以Get查询的结果为准
+func (s *QuotaService) Usage() []QuotaUsage {
+	return s.wechat.quota.usage(time.Now())
+}
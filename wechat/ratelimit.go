@@ -0,0 +1,254 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotit/errors"
+)
+
+// beijing 微信每日调用次数在北京时间0点重置
+var beijing = time.FixedZone("CST", 8*60*60)
+
+// defaultDailyQuotas 微信公布的接口每日调用次数上限，可以通过WithDailyQuotas修改
+var defaultDailyQuotas = map[string]int{
+	"/cgi-bin/token":     2000,
+	"/cgi-bin/user/info": 5000000,
+}
+
+// RateLimit 一组接口共用的令牌桶限流，超过限制的请求等待令牌，ctx被取消时返回ctx的错误
+type RateLimit struct {
+	Name      string   // 分组名称，用于日志
+	Endpoints []string // 分组包含的接口路径，如 /card/get；以*结尾时匹配前缀，如 /card/*；为空时匹配所有接口
+	Rate      float64  // 每秒产生的令牌数，即平均每秒允许的请求数
+	Burst     int      // 令牌桶的容量，即允许的突发请求数
+}
+
+// match 判断接口路径是否属于分组
+func (l *RateLimit) match(path string) bool {
+	if len(l.Endpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range l.Endpoints {
+		if prefix := strings.TrimSuffix(endpoint, "*"); prefix != endpoint {
+			if strings.Contains(path, prefix) {
+				return true
+			}
+		} else if strings.HasSuffix(path, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate 校验限流配置
+func (l *RateLimit) validate() error {
+	if l.Rate <= 0 {
+		return errors.Errorf("限流分组 %s 的Rate必须大于0", l.Name)
+	}
+	if l.Burst < 1 {
+		return errors.Errorf("限流分组 %s 的Burst不能小于1", l.Name)
+	}
+	return nil
+}
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	limit  RateLimit
+	mu     sync.Mutex
+	tokens float64   // 当前的令牌数，为负数时表示已被预订的令牌
+	last   time.Time // 上次计算令牌数的时间
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst)}
+}
+
+// reserve 预订一个令牌，返回需要等待的时间
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+}
+
+// cancel 归还预订的令牌
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// wait 等待一个令牌
+func (b *tokenBucket) wait(ctx context.Context) error {
+	d := b.reserve(time.Now())
+	if d == 0 {
+		return nil
+	}
+	if err := sleepContext(ctx, d); err != nil {
+		b.cancel()
+		return err
+	}
+	return nil
+}
+
+// QuotaLockedError 接口当天已返回过45009，在北京时间0点之前不再请求
+type QuotaLockedError struct {
+	Endpoint string    // 接口路径
+	Until    time.Time // 重新开放的时间
+}
+
+func (e *QuotaLockedError) Error() string {
+	return fmt.Sprintf("微信接口 %s 已超过每日调用次数限制，%s 之前不再请求", e.Endpoint, e.Until.Format("2006-01-02 15:04:05"))
+}
+
+// QuotaUsage 本地统计的接口当天调用次数
+type QuotaUsage struct {
+	Endpoint    string    // 接口路径
+	Used        int       // 本进程当天的调用次数
+	DailyLimit  int       // 已知的每日调用次数上限，未知时为0
+	LockedUntil time.Time // 返回45009后暂停请求直到这个时间，未被锁定时为零值
+}
+
+// quotaTracker 统计每个接口当天的调用次数，记录返回45009的接口
+type quotaTracker struct {
+	mu     sync.Mutex
+	limits map[string]int       // 接口的每日调用次数上限
+	day    string               // 统计的日期，北京时间
+	used   map[string]int       // 接口当天的调用次数
+	locked map[string]time.Time // 返回45009的接口及重新开放的时间
+}
+
+func newQuotaTracker(limits map[string]int) *quotaTracker {
+	t := &quotaTracker{limits: map[string]int{}}
+	for endpoint, limit := range limits {
+		t.limits[endpoint] = limit
+	}
+	return t
+}
+
+// rollover 日期变化时清空统计，调用前需持有锁
+func (t *quotaTracker) rollover(now time.Time) {
+	day := now.In(beijing).Format("2006-01-02")
+	if day != t.day {
+		t.day = day
+		t.used = map[string]int{}
+		t.locked = map[string]time.Time{}
+	}
+}
+
+// check 接口被锁定时返回*QuotaLockedError
+func (t *quotaTracker) check(endpoint string, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover(now)
+	if until, ok := t.locked[endpoint]; ok {
+		return &QuotaLockedError{Endpoint: endpoint, Until: until}
+	}
+	return nil
+}
+
+// record 记录一次调用，返回调用后的次数及已知的上限
+func (t *quotaTracker) record(endpoint string, now time.Time) (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover(now)
+	t.used[endpoint]++
+	return t.used[endpoint], t.limit(endpoint)
+}
+
+// lock 接口返回45009，锁定到北京时间的第二天0点
+func (t *quotaTracker) lock(endpoint string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover(now)
+	y, m, d := now.In(beijing).Date()
+	t.locked[endpoint] = time.Date(y, m, d+1, 0, 0, 0, 0, beijing)
+}
+
+// reset 调用clear_quota后清空统计
+func (t *quotaTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.day = ""
+}
+
+// limit 接口已知的每日调用次数上限，BaseURL可能带有路径前缀，所以只比较结尾，调用前需持有锁
+func (t *quotaTracker) limit(endpoint string) int {
+	for path, limit := range t.limits {
+		if strings.HasSuffix(endpoint, path) {
+			return limit
+		}
+	}
+	return 0
+}
+
+// usage 返回当天所有被调用过或被锁定的接口的统计
+func (t *quotaTracker) usage(now time.Time) []QuotaUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover(now)
+	usages := map[string]*QuotaUsage{}
+	for endpoint, used := range t.used {
+		usages[endpoint] = &QuotaUsage{Endpoint: endpoint, Used: used, DailyLimit: t.limit(endpoint)}
+	}
+	for endpoint, until := range t.locked {
+		if _, ok := usages[endpoint]; !ok {
+			usages[endpoint] = &QuotaUsage{Endpoint: endpoint, DailyLimit: t.limit(endpoint)}
+		}
+		usages[endpoint].LockedUntil = until
+	}
+	result := make([]QuotaUsage, 0, len(usages))
+	for _, u := range usages {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Endpoint < result[j].Endpoint })
+	return result
+}
+
+// limitInterceptor 发送请求前按限流分组等待令牌，接口被锁定时直接返回*QuotaLockedError
+// 请求后统计调用次数，返回45009时锁定该接口到北京时间第二天0点
+func (w *APIClient) limitInterceptor(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request, info *CallInfo) error {
+		if err := w.quota.check(info.Endpoint, time.Now()); err != nil {
+			return err
+		}
+		for _, bucket := range w.buckets {
+			if bucket.limit.match(info.Endpoint) {
+				if err := bucket.wait(ctx); err != nil {
+					return err
+				}
+				break
+			}
+		}
+
+		err := next(ctx, req, info)
+		if info.StatusCode == 0 {
+			return err
+		}
+		now := time.Now()
+		if used, limit := w.quota.record(info.Endpoint, now); used == limit {
+			w.logger.Warn("微信接口调用次数已达到每日上限", "endpoint", info.Endpoint, "used", used, "limit", limit)
+		}
+		if info.Errcode == ErrcodeQuotaExceeded {
+			w.quota.lock(info.Endpoint, now)
+			w.logger.Error("微信接口超过每日调用次数限制，暂停请求到明天", "endpoint", info.Endpoint)
+		}
+		return err
+	}
+}
@@ -0,0 +1,245 @@
+package wechat
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gotit/errors"
+)
+
+const durationRegistryIdle = time.Minute // 没有需要刷新的公众号时，Registry检查的间隔
+
+// Registry 管理多个公众号的APIClient，按AppID或原始ID路由
+// 所有APIClient共用一个http.Client，自治策略的AccessToken由Registry的一个刷新循环统一维护
+// 可以在运行时增加或移除公众号
+type Registry struct {
+	client  *http.Client // 所有APIClient共用的http.Client
+	options []Option     // 所有APIClient共用的Option
+
+	mu       sync.RWMutex
+	entries  map[string]*registryEntry // key为AppID
+	original map[string]string         // 原始ID到AppID的映射
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// registryEntry Registry中的一个公众号
+type registryEntry struct {
+	client      *APIClient
+	nextRefresh time.Time // 下一次需要刷新AccessToken的时间，非自治策略为零值
+}
+
+// NewRegistry 创建Registry并加入configs中的公众号，opts会用于所有APIClient
+// 有公众号配置无效时，返回包含所有问题的*ConfigError
+// opts中的WithTransport会被所有APIClient共用；WithProxy会为每个APIClient复制Transport，需要共用连接池时请用WithTransport
+func NewRegistry(configs []*APIConfig, opts ...Option) (*Registry, error) {
+	r := &Registry{
+		client:   &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		options:  append([]Option{}, opts...),
+		entries:  make(map[string]*registryEntry),
+		original: make(map[string]string),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	problems := []string{}
+	for i, config := range configs {
+		if _, err := r.Add(config); err != nil {
+			if e, ok := err.(*ConfigError); ok {
+				name := fmt.Sprintf("第%d个公众号", i+1)
+				if config != nil && len(config.AppID) > 0 {
+					name = "公众号 " + config.AppID
+				}
+				for _, p := range e.Problems {
+					problems = append(problems, fmt.Sprintf("%s %s", name, p))
+				}
+			} else {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	if len(problems) > 0 {
+		r.closeClients()
+		return nil, &ConfigError{Problems: problems}
+	}
+	go r.loop()
+	return r, nil
+}
+
+// Add 加入一个公众号，opts在NewRegistry的opts之后应用，AppID或原始ID已存在时返回错误
+func (r *Registry) Add(config *APIConfig, opts ...Option) (*APIClient, error) {
+	if config == nil {
+		return nil, &ConfigError{Problems: []string{problemNilConfig}}
+	}
+	all := make([]Option, 0, len(r.options)+len(opts)+2)
+	all = append(all, WithHTTPClient(r.client))
+	all = append(all, r.options...)
+	all = append(all, opts...)
+	all = append(all, func(w *APIClient) error {
+		w.sharedRefresh = true
+		return nil
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[config.AppID]; ok {
+		return nil, errors.Errorf("公众号 %s 已存在", config.AppID)
+	}
+	if _, ok := r.original[config.OriginalID]; ok && len(config.OriginalID) > 0 {
+		return nil, errors.Errorf("原始ID %s 已存在", config.OriginalID)
+	}
+	w, err := NewClient(config, all...)
+	if err != nil {
+		return nil, err
+	}
+	entry := &registryEntry{client: w}
+	if w.accessTokenCachePolicy == CachePolicyAutonomy {
+		entry.nextRefresh = time.Now()
+		r.notify()
+	}
+	r.entries[w.AppID] = entry
+	if len(w.OriginalID) > 0 {
+		r.original[w.OriginalID] = w.AppID
+	}
+	return w, nil
+}
+
+// Remove 移除一个公众号并关闭其APIClient，公众号不存在时返回false
+func (r *Registry) Remove(appID string) bool {
+	r.mu.Lock()
+	entry, ok := r.entries[appID]
+	if ok {
+		delete(r.entries, appID)
+		delete(r.original, entry.client.OriginalID)
+	}
+	r.mu.Unlock()
+	if ok {
+		entry.client.Close()
+	}
+	return ok
+}
+
+// Get 按AppID获取公众号的APIClient
+func (r *Registry) Get(appID string) (*APIClient, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[appID]
+	if !ok {
+		return nil, false
+	}
+	return entry.client, true
+}
+
+// Lookup 按AppID或原始ID获取公众号的APIClient，可以直接用消息的ToUserName查找
+func (r *Registry) Lookup(id string) (*APIClient, bool) {
+	r.mu.RLock()
+	if appID, ok := r.original[id]; ok {
+		id = appID
+	}
+	r.mu.RUnlock()
+	return r.Get(id)
+}
+
+// AppIDs 返回所有公众号的AppID，按字母排序
+func (r *Registry) AppIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	appIDs := make([]string, 0, len(r.entries))
+	for appID := range r.entries {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Strings(appIDs)
+	return appIDs
+}
+
+// Close 停止刷新循环并关闭所有APIClient
+func (r *Registry) Close() error {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+	r.closeClients()
+	return nil
+}
+
+// closeClients 关闭所有APIClient
+func (r *Registry) closeClients() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, entry := range r.entries {
+		entry.client.Close()
+	}
+}
+
+// notify 唤醒刷新循环，重新计算下一次刷新的时间
+func (r *Registry) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop 刷新循环，所有自治策略公众号的AccessToken都由这一个循环维护
+func (r *Registry) loop() {
+	defer close(r.done)
+	for {
+		timer := time.NewTimer(r.refreshDue(time.Now()))
+		select {
+		case <-timer.C:
+		case <-r.wake:
+			timer.Stop()
+		case <-r.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// refreshDue 并发刷新所有到期的AccessToken，返回距下一次需要刷新的时间
+func (r *Registry) refreshDue(now time.Time) time.Duration {
+	due := []*registryEntry{}
+	r.mu.RLock()
+	for _, entry := range r.entries {
+		if !entry.nextRefresh.IsZero() && !entry.nextRefresh.After(now) {
+			due = append(due, entry)
+		}
+	}
+	r.mu.RUnlock()
+
+	next := make([]time.Duration, len(due))
+	var wg sync.WaitGroup
+	for i, entry := range due {
+		wg.Add(1)
+		go func(i int, w *APIClient) {
+			defer wg.Done()
+			next[i] = w.refreshIfDue()
+		}(i, entry.client)
+	}
+	wg.Wait()
+
+	now = time.Now()
+	wait := durationRegistryIdle
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, entry := range due {
+		entry.nextRefresh = now.Add(next[i])
+	}
+	for _, entry := range r.entries {
+		if entry.nextRefresh.IsZero() {
+			continue
+		}
+		if d := entry.nextRefresh.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
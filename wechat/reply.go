@@ -0,0 +1,231 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/gotit/errors"
+)
+
+// 被动回复的消息类型 MsgType
+const (
+	ReplyTypeText                    = "text"                      // 文本消息
+	ReplyTypeImage                   = "image"                     // 图片消息
+	ReplyTypeVoice                   = "voice"                     // 语音消息
+	ReplyTypeVideo                   = "video"                     // 视频消息
+	ReplyTypeMusic                   = "music"                     // 音乐消息
+	ReplyTypeNews                    = "news"                      // 图文消息
+	ReplyTypeTransferCustomerService = "transfer_customer_service" // 转发到客服
+)
+
+const (
+	maxReplyArticles   = 8    // 图文消息最多的图文数
+	maxReplyTextLength = 2048 // 文本消息内容的最大字节数
+)
+
+// Reply 被动回复的消息，可以传给Context.Reply或MarshalReply，如TextReply{}或&TextReply{}
+// ToUserName、FromUserName和CreateTime根据收到的推送自动填写
+type Reply interface {
+	build(x *replyXML) error
+}
+
+// TextReply 回复文本消息
+type TextReply struct {
+	Content string // 回复的消息内容，换行使用\n，最多2048字节
+}
+
+// ImageReply 回复图片消息
+type ImageReply struct {
+	MediaID string // 通过素材管理接口上传的图片的媒体id
+}
+
+// VoiceReply 回复语音消息
+type VoiceReply struct {
+	MediaID string // 通过素材管理接口上传的语音的媒体id
+}
+
+// VideoReply 回复视频消息
+type VideoReply struct {
+	MediaID     string // 通过素材管理接口上传的视频的媒体id
+	Title       string // 视频消息的标题，可选
+	Description string // 视频消息的描述，可选
+}
+
+// MusicReply 回复音乐消息
+type MusicReply struct {
+	Title        string // 音乐标题，可选
+	Description  string // 音乐描述，可选
+	MusicURL     string // 音乐链接，可选
+	HQMusicURL   string // 高质量音乐链接，WIFI环境优先使用，可选
+	ThumbMediaID string // 缩略图的媒体id，通过素材管理接口上传
+}
+
+// Article 图文消息中的一条图文
+type Article struct {
+	Title       string // 图文消息标题
+	Description string // 图文消息描述
+	PicURL      string // 图片链接，大图360*200，小图200*200
+	URL         string // 点击图文消息跳转链接
+}
+
+// NewsReply 回复图文消息，最多8条图文
+type NewsReply struct {
+	Articles []Article
+}
+
+// TransferCustomerServiceReply 把消息转发到客服，KfAccount为空时转发给任意在线客服
+type TransferCustomerServiceReply struct {
+	KfAccount string // 指定接待的客服帐号，格式为 帐号前缀@公众号微信号
+}
+
+// replyXML 被动回复的XML，不同消息类型只输出对应的字段
+type replyXML struct {
+	XMLName      xml.Name        `xml:"xml"`
+	ToUserName   cdata           `xml:"ToUserName"`
+	FromUserName cdata           `xml:"FromUserName"`
+	CreateTime   int64           `xml:"CreateTime"`
+	MsgType      cdata           `xml:"MsgType"`
+	Content      *cdata          `xml:"Content"`
+	Image        *replyMedia     `xml:"Image"`
+	Voice        *replyMedia     `xml:"Voice"`
+	Video        *replyVideo     `xml:"Video"`
+	Music        *replyMusic     `xml:"Music"`
+	ArticleCount int             `xml:"ArticleCount,omitempty"`
+	Articles     *replyArticles  `xml:"Articles"`
+	TransInfo    *replyTransInfo `xml:"TransInfo"`
+}
+
+type replyMedia struct {
+	MediaID cdata `xml:"MediaId"`
+}
+
+type replyVideo struct {
+	MediaID     cdata `xml:"MediaId"`
+	Title       cdata `xml:"Title,omitempty"`
+	Description cdata `xml:"Description,omitempty"`
+}
+
+type replyMusic struct {
+	Title        cdata `xml:"Title,omitempty"`
+	Description  cdata `xml:"Description,omitempty"`
+	MusicURL     cdata `xml:"MusicUrl,omitempty"`
+	HQMusicURL   cdata `xml:"HQMusicUrl,omitempty"`
+	ThumbMediaID cdata `xml:"ThumbMediaId"`
+}
+
+type replyArticles struct {
+	Items []replyArticle `xml:"item"`
+}
+
+type replyArticle struct {
+	Title       cdata `xml:"Title"`
+	Description cdata `xml:"Description"`
+	PicURL      cdata `xml:"PicUrl"`
+	URL         cdata `xml:"Url"`
+}
+
+type replyTransInfo struct {
+	KfAccount cdata `xml:"KfAccount"`
+}
+
+func (r TextReply) build(x *replyXML) error {
+	if len(r.Content) == 0 {
+		return errors.New("文本消息的内容不能为空")
+	}
+	if len(r.Content) > maxReplyTextLength {
+		return errors.Errorf("文本消息的内容不能超过%d字节", maxReplyTextLength)
+	}
+	content := cdata(r.Content)
+	x.MsgType, x.Content = ReplyTypeText, &content
+	return nil
+}
+
+func (r ImageReply) build(x *replyXML) error {
+	if len(r.MediaID) == 0 {
+		return errors.New("图片消息的MediaID不能为空")
+	}
+	x.MsgType, x.Image = ReplyTypeImage, &replyMedia{MediaID: cdata(r.MediaID)}
+	return nil
+}
+
+func (r VoiceReply) build(x *replyXML) error {
+	if len(r.MediaID) == 0 {
+		return errors.New("语音消息的MediaID不能为空")
+	}
+	x.MsgType, x.Voice = ReplyTypeVoice, &replyMedia{MediaID: cdata(r.MediaID)}
+	return nil
+}
+
+func (r VideoReply) build(x *replyXML) error {
+	if len(r.MediaID) == 0 {
+		return errors.New("视频消息的MediaID不能为空")
+	}
+	x.MsgType = ReplyTypeVideo
+	x.Video = &replyVideo{
+		MediaID:     cdata(r.MediaID),
+		Title:       cdata(r.Title),
+		Description: cdata(r.Description),
+	}
+	return nil
+}
+
+func (r MusicReply) build(x *replyXML) error {
+	if len(r.ThumbMediaID) == 0 {
+		return errors.New("音乐消息的ThumbMediaID不能为空")
+	}
+	x.MsgType = ReplyTypeMusic
+	x.Music = &replyMusic{
+		Title:        cdata(r.Title),
+		Description:  cdata(r.Description),
+		MusicURL:     cdata(r.MusicURL),
+		HQMusicURL:   cdata(r.HQMusicURL),
+		ThumbMediaID: cdata(r.ThumbMediaID),
+	}
+	return nil
+}
+
+func (r NewsReply) build(x *replyXML) error {
+	if len(r.Articles) == 0 {
+		return errors.New("图文消息至少需要1条图文")
+	}
+	if len(r.Articles) > maxReplyArticles {
+		return errors.Errorf("图文消息最多%d条图文，实际%d条", maxReplyArticles, len(r.Articles))
+	}
+	x.MsgType, x.ArticleCount = ReplyTypeNews, len(r.Articles)
+	x.Articles = &replyArticles{}
+	for _, a := range r.Articles {
+		x.Articles.Items = append(x.Articles.Items, replyArticle{
+			Title:       cdata(a.Title),
+			Description: cdata(a.Description),
+			PicURL:      cdata(a.PicURL),
+			URL:         cdata(a.URL),
+		})
+	}
+	return nil
+}
+
+func (r TransferCustomerServiceReply) build(x *replyXML) error {
+	x.MsgType = ReplyTypeTransferCustomerService
+	if len(r.KfAccount) > 0 {
+		x.TransInfo = &replyTransInfo{KfAccount: cdata(r.KfAccount)}
+	}
+	return nil
+}
+
+// MarshalReply 生成对msg的被动回复XML，发送方和接收方与msg互换，CreateTime为当前时间
+// 回复不符合微信的限制时返回error，如图文超过8条、MediaID为空
+// 安全模式下Server会加密返回的XML，单独使用时可以用MessageCrypter.EncryptReply加密
+func MarshalReply(msg *MessageHeader, r Reply) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("被动回复不能为nil")
+	}
+	x := &replyXML{
+		ToUserName:   cdata(msg.FromUserName),
+		FromUserName: cdata(msg.ToUserName),
+		CreateTime:   time.Now().Unix(),
+	}
+	if err := r.build(x); err != nil {
+		return nil, err
+	}
+	return xml.Marshal(x)
+}
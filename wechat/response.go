@@ -0,0 +1,169 @@
+package wechat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gotit/errors"
+)
+
+const (
+	sniffSize           = 4096     // 读取返回数据开头多少字节用于判断errcode
+	maxDrainSize        = 64 << 10 // 关闭返回数据前最多丢弃的字节数，超出时不再复用连接
+	defaultDebugCapture = 4096     // 调试日志默认最多输出的返回数据字节数
+)
+
+// readResponse 读取并关闭返回数据，不会把整个返回数据读入内存
+// v是io.Writer时，返回数据不是微信的错误时直接写入v，用于下载媒体文件等二进制数据
+// 否则以json流式解析到v，v为nil时只判断errcode
+// 返回微信的错误码、解析返回数据的错误，以及读取返回数据开头时的网络错误
+func (w *APIClient) readResponse(req *http.Request, resp *http.Response, v interface{}) (apiErr *APIError, decodeErr error, err error) {
+	defer func() {
+		// 丢弃剩余的数据后关闭，使Transport可以复用连接
+		io.CopyN(ioutil.Discard, resp.Body, maxDrainSize)
+		resp.Body.Close()
+	}()
+
+	br := bufio.NewReaderSize(resp.Body, sniffSize)
+	head, err := br.Peek(sniffSize)
+	complete := err == io.EOF // 返回数据不超过sniffSize，已经全部在head中
+	if err != nil && !complete {
+		return nil, nil, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	isJSON := isJSONContentType(contentType) && looksLikeJSON(head)
+	if isJSON {
+		if complete {
+			apiErr = parseAPIError(req.URL.Path, head)
+		} else {
+			apiErr = sniffAPIError(req.URL.Path, head)
+		}
+	}
+
+	if writer, ok := v.(io.Writer); ok {
+		if apiErr == nil && resp.StatusCode < 400 {
+			n, err := io.Copy(writer, br)
+			w.logger.Debug("微信接口返回", "url", redactURL(req.URL), "status", resp.StatusCode, "content_type", contentType, "length", n)
+			return nil, err, nil
+		}
+		v = nil // 返回的是错误信息，不写入v
+	}
+
+	capture := &limitedBuffer{limit: w.debugCaptureLimit()}
+
+	switch {
+	case !isJSON:
+		capture.Write(head)
+		if v != nil {
+			decodeErr = errors.Errorf("微信接口 %s 返回的数据不是json，Content-Type %s", req.URL.Path, contentType)
+		}
+	case complete:
+		capture.Write(head)
+		if v != nil && len(bytes.TrimSpace(head)) > 0 {
+			decodeErr = json.Unmarshal(head, v)
+		}
+	case v != nil:
+		decodeErr = json.NewDecoder(io.TeeReader(br, capture)).Decode(v)
+	default:
+		capture.Write(head)
+	}
+	if apiErr == nil && resp.StatusCode >= 400 {
+		decodeErr = errors.Errorf("微信接口 %s 返回HTTP状态 %d", req.URL.Path, resp.StatusCode)
+	}
+
+	if capture.limit > 0 {
+		body := fmt.Sprintf("<%d bytes>", capture.total)
+		if isJSON && !capture.truncated() && (complete || decodeErr == nil) {
+			body = redactBody(capture.buf)
+		}
+		w.logger.Debug("微信接口返回", "url", redactURL(req.URL), "status", resp.StatusCode, "body", body)
+	}
+	return apiErr, decodeErr, nil
+}
+
+// debugCaptureLimit 调试日志最多输出的返回数据字节数，默认日志不输出debug级别时为0
+func (w *APIClient) debugCaptureLimit() int {
+	if l, ok := w.logger.(*stdLogger); ok && l.level > logLevels[LogLevelDebug] {
+		return 0
+	}
+	return w.debugCapture
+}
+
+// sniffAPIError 从返回数据的开头查找errcode，用于不能一次读入内存的返回数据
+// 只查找最外层对象的errcode和errmsg，嵌套在数据中的同名字段不是微信的错误码；查找到head被截断处为止
+func sniffAPIError(endpoint string, head []byte) *APIError {
+	dec := json.NewDecoder(bytes.NewReader(head))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	var code int
+	var msg string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch tok {
+		case "errcode":
+			err = dec.Decode(&code)
+		case "errmsg":
+			err = dec.Decode(&msg)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if code == 0 {
+		return nil
+	}
+	apiErr := &APIError{Code: code, Msg: msg, Endpoint: endpoint}
+	if m := ridPattern.FindStringSubmatch(msg); m != nil {
+		apiErr.Rid = m[1]
+	}
+	return apiErr
+}
+
+// isJSONContentType 判断Content-Type是否可能是json，微信的部分接口以text/plain返回json
+func isJSONContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return len(contentType) == 0 || strings.Contains(contentType, "json") || strings.HasPrefix(contentType, "text/")
+}
+
+// looksLikeJSON 判断返回数据是否以json对象或数组开头，空数据也视为json
+func looksLikeJSON(head []byte) bool {
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	return len(trimmed) == 0 || trimmed[0] == '{' || trimmed[0] == '['
+}
+
+// limitedBuffer 最多保存limit字节的io.Writer，超出的部分只计数，用于调试日志
+type limitedBuffer struct {
+	buf   []byte
+	limit int
+	total int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.total += len(p)
+	if room := b.limit - len(b.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.buf = append(b.buf, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// truncated 是否有数据因超出limit没有保存
+func (b *limitedBuffer) truncated() bool {
+	return b.total > len(b.buf)
+}
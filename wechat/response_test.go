@@ -0,0 +1,62 @@
+package wechat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestSniffAPIError 超过sniffSize的返回数据只从开头查找最外层的errcode
+func TestSniffAPIError(t *testing.T) {
+	padding := strings.Repeat("x", sniffSize)
+	tests := []struct {
+		name    string
+		body    string
+		wantErr *APIError
+	}{
+		{
+			name:    "最外层的错误码",
+			body:    fmt.Sprintf(`{"errcode":40003,"errmsg":"invalid openid rid: 5f1a2b3c-1a2b3c4d-5e6f7a8b","data":%q}`, padding),
+			wantErr: &APIError{Code: 40003, Msg: "invalid openid rid: 5f1a2b3c-1a2b3c4d-5e6f7a8b", Rid: "5f1a2b3c-1a2b3c4d-5e6f7a8b"},
+		},
+		{
+			name:    "错误码在其他字段之后",
+			body:    fmt.Sprintf(`{"item":{"errcode":1},"errmsg":"system \"busy\"","errcode":-1,"data":%q}`, padding),
+			wantErr: &APIError{Code: -1, Msg: `system "busy"`},
+		},
+		{
+			name: "成功",
+			body: fmt.Sprintf(`{"errcode":0,"errmsg":"ok","data":%q}`, padding),
+		},
+		{
+			name: "嵌套对象中的errcode",
+			body: fmt.Sprintf(`{"item_list":[{"errcode":40001,"errmsg":"nested"}],"data":%q}`, padding),
+		},
+		{
+			name: "字符串中的errcode",
+			body: fmt.Sprintf(`{"content":"\"errcode\":40001","data":%q}`, padding),
+		},
+		{
+			name: "errcode在截断处之后",
+			body: fmt.Sprintf(`{"data":%q,"errcode":40001}`, padding),
+		},
+		{
+			name: "数组",
+			body: fmt.Sprintf(`[{"errcode":40001},%q]`, padding),
+		},
+	}
+	for _, tt := range tests {
+		head := []byte(tt.body)[:sniffSize]
+		got := sniffAPIError("/test", head)
+		if tt.wantErr == nil {
+			if got != nil {
+				t.Errorf("%s: 不应返回错误，实际返回 %v", tt.name, got)
+			}
+			continue
+		}
+		tt.wantErr.Endpoint = "/test"
+		if got == nil || *got != *tt.wantErr {
+			t.Errorf("%s: 应返回 %+v，实际返回 %+v", tt.name, tt.wantErr, got)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RetryPolicy 临时故障(系统繁忙、调用太频繁、网络错误、5xx)的重试策略
+// 写接口(如激活会员卡)只在确定微信没有处理过请求时重试，见 WithRetrySafe
+type RetryPolicy struct {
+	MaxAttempts       int           // 包括第一次和切换备用地址在内最多发送的次数，不大于1时不重试
+	InitialBackoff    time.Duration // 第一次重试前的等待时间
+	MaxBackoff        time.Duration // 等待时间的上限
+	Multiplier        float64       // 每次重试等待时间的倍数
+	Jitter            float64       // 等待时间随机浮动的比例，0到1之间
+	RetryableErrcodes []int         // 需要重试的errcode
+}
+
+// DefaultRetryPolicy 默认的重试策略，最多发送3次，errcode -1和45011时重试
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:       3,
+		InitialBackoff:    time.Millisecond * 200,
+		MaxBackoff:        time.Second * 2,
+		Multiplier:        2,
+		Jitter:            0.2,
+		RetryableErrcodes: []int{ErrcodeSystemBusy, ErrcodeTooFrequent},
+	}
+}
+
+// NoRetryPolicy 不重试的策略
+func NoRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// validate 校验重试策略
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts > 1 {
+		if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+			return errors.New("重试等待时间不能为负数")
+		}
+		if p.Multiplier < 1 {
+			return errors.New("重试等待时间的倍数不能小于1")
+		}
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.New("重试等待时间的随机浮动比例应在0到1之间")
+	}
+	return nil
+}
+
+// backoff 第retry次重试前的等待时间，retry从1开始
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// retryableErrcode errcode是否需要重试
+func (p RetryPolicy) retryableErrcode(errcode int) bool {
+	for _, code := range p.RetryableErrcodes {
+		if code == errcode {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyPostEndpoints 只读取数据的POST接口，重复发送没有副作用
+var readOnlyPostEndpoints = []string{
+	"/card/batchget",
+	"/card/get",
+	"/card/user/getcardlist",
+	"/card/membercard/userinfo/get",
+	"/card/membercard/activatetempinfo/get",
+	"/card/code/decrypt",
+	"/cgi-bin/openapi/quota/get",
+	"/cgi-bin/openapi/rid/get",
+}
+
+// sideEffectGetEndpoints 有副作用的GET接口，如网页授权的code只能使用一次
+var sideEffectGetEndpoints = []string{
+	"/sns/oauth2/access_token",
+}
+
+// WithRetrySafe 标记ctx发起的请求重复发送也没有副作用，遇到临时故障时可以重试
+// 写接口需要调用方自己保证幂等后再使用
+func WithRetrySafe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyRetrySafe, true)
+}
+
+// isRetrySafe 判断请求重复发送是否没有副作用
+func isRetrySafe(ctx context.Context, req *http.Request) bool {
+	if ctx.Value(ctxKeyRetrySafe) != nil {
+		return true
+	}
+	switch req.Method {
+	case "GET":
+		return !hasEndpointSuffix(req.URL.Path, sideEffectGetEndpoints)
+	case "POST":
+		return hasEndpointSuffix(req.URL.Path, readOnlyPostEndpoints)
+	}
+	return false
+}
+
+// hasEndpointSuffix 判断path是否为endpoints之一，BaseURL可能带有路径前缀，所以只比较结尾
+func hasEndpointSuffix(path string, endpoints []string) bool {
+	for _, endpoint := range endpoints {
+		if strings.HasSuffix(path, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry 判断一次请求的结果是否需要按重试策略重新发送
+// 建立连接失败和45011时微信一定没有处理请求，总是可以重试，其他临时故障只在请求可以安全重试时重试
+func (p RetryPolicy) shouldRetry(ctx context.Context, req *http.Request, resp *http.Response, apiErr *APIError, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch {
+	case apiErr != nil:
+		if !p.retryableErrcode(apiErr.Code) {
+			return false
+		}
+		return apiErr.Code == ErrcodeTooFrequent || isRetrySafe(ctx, req)
+	case err != nil:
+		if isLocalRejection(err) {
+			return false
+		}
+		return isDialError(err) || isRetrySafe(ctx, req)
+	case resp != nil && resp.StatusCode >= 500:
+		return isRetrySafe(ctx, req)
+	}
+	return false
+}
+
+// isLocalRejection 判断err是否为本地拒绝发送请求的错误，重试也不会成功
+func isLocalRejection(err error) bool {
+	var locked *QuotaLockedError
+	return errors.As(err, &locked) || IsCircuitOpen(err)
+}
+
+// sleepContext 等待d，ctx被取消时提前返回ctx的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resetResult 重新发送请求前把v指向的值清零，避免上一次返回数据中的字段残留到重试的结果中
+// io.Writer不清零，已写入数据时不会重试
+func resetResult(v interface{}) {
+	if _, ok := v.(io.Writer); ok {
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return
+	}
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+}
+
+// cloneRequest 复制请求并重新设置请求体，使请求可以再次发送
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
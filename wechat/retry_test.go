@@ -0,0 +1,113 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy 与默认策略相同，只是缩短等待时间
+func testRetryPolicy() RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.InitialBackoff, p.MaxBackoff = time.Millisecond, time.Millisecond
+	return p
+}
+
+// TestRetryCountsFailover 切换备用地址重新发送的次数计入MaxAttempts，重试与切换不会相乘
+func TestRetryCountsFailover(t *testing.T) {
+	var calls int32
+	unavailable := func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	primary := newTestServer(t, unavailable)
+	backup1 := newTestServer(t, unavailable)
+	backup2 := newTestServer(t, unavailable)
+	w := newTestClient(t, primary.URL, WithRetryPolicy(testRetryPolicy()),
+		WithFailoverHosts(testHost(t, backup1.URL), testHost(t, backup2.URL)))
+
+	req, err := w.NewRequest("GET", "cgi-bin/get_api_domain_ip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Do(context.Background(), req, nil); err == nil {
+		t.Fatal("所有地址都返回503时应返回错误")
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("共发送%d次，应为MaxAttempts 3次", n)
+	}
+}
+
+// TestRetryPolicy 临时故障按策略重试，写接口只在确定微信没有处理过请求或调用方声明可以安全重试时重试
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		retrySafe bool
+		responses []string // 依次返回的数据，"503"表示返回HTTP 503，超出后重复最后一个
+		wantCalls int32
+		wantCode  int // 期望返回的errcode，0表示成功，-503表示返回HTTP状态错误
+	}{
+		{"GET系统繁忙", "GET", "cgi-bin/get_api_domain_ip", false, []string{`{"errcode":-1}`}, 3, ErrcodeSystemBusy},
+		{"GET重试后成功", "GET", "cgi-bin/get_api_domain_ip", false, []string{"503", `{"errcode":-1}`, `{"errcode":0}`}, 3, 0},
+		{"不重试的错误码", "GET", "cgi-bin/get_api_domain_ip", false, []string{`{"errcode":40013}`}, 1, ErrcodeInvalidAppID},
+		{"只读的POST", "POST", "card/get", false, []string{`{"errcode":-1}`}, 3, ErrcodeSystemBusy},
+		{"写接口系统繁忙", "POST", "card/membercard/activate", false, []string{`{"errcode":-1}`}, 1, ErrcodeSystemBusy},
+		{"写接口5xx", "POST", "card/membercard/activate", false, []string{"503"}, 1, -http.StatusServiceUnavailable},
+		{"写接口调用太频繁", "POST", "card/membercard/activate", false, []string{`{"errcode":45011}`}, 3, ErrcodeTooFrequent},
+		{"声明可以安全重试的写接口", "POST", "card/membercard/activate", true, []string{`{"errcode":-1}`, `{"errcode":0}`}, 2, 0},
+		{"网页授权的code", "GET", "sns/oauth2/access_token?code=CODE", false, []string{`{"errcode":-1}`}, 1, ErrcodeSystemBusy},
+	}
+	for _, tt := range tests {
+		var calls int32
+		srv := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&calls, 1))
+			if n > len(tt.responses) {
+				n = len(tt.responses)
+			}
+			if body := tt.responses[n-1]; body == "503" {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				fmt.Fprint(rw, body)
+			}
+		})
+		w := newTestClient(t, srv.URL, WithRetryPolicy(testRetryPolicy()))
+
+		var body interface{}
+		if tt.method == "POST" {
+			body = map[string]string{"card_id": "CARD_ID"}
+		}
+		req, err := w.NewRequest(tt.method, tt.path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if tt.retrySafe {
+			ctx = WithRetrySafe(ctx)
+		}
+		_, err = w.Do(ctx, req, nil)
+
+		if n := atomic.LoadInt32(&calls); n != tt.wantCalls {
+			t.Errorf("%s: 发送了%d次，应为%d次", tt.name, n, tt.wantCalls)
+		}
+		apiErr, _ := AsAPIError(err)
+		switch tt.wantCode {
+		case 0:
+			if err != nil {
+				t.Errorf("%s: 应成功，实际返回 %v", tt.name, err)
+			}
+		case -http.StatusServiceUnavailable:
+			if err == nil || apiErr != nil {
+				t.Errorf("%s: 应返回HTTP状态错误，实际返回 %v", tt.name, err)
+			}
+		default:
+			if apiErr == nil || apiErr.Code != tt.wantCode {
+				t.Errorf("%s: 应返回errcode %d，实际返回 %v", tt.name, tt.wantCode, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package wechat
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotit/errors"
+)
+
+const (
+	maxNotifyBodySize   = 1 << 20                 // 微信推送消息的最大字节数
+	defaultReplyTimeout = 4500 * time.Millisecond // 默认的被动回复时限，微信5秒内收不到回复会断开并重试
+)
+
+// MessageHandler 处理一条微信推送，返回error时记录日志并回复success
+type MessageHandler func(c *Context) error
+
+// Context 一次微信推送的处理上下文
+type Context struct {
+	Request *http.Request // 微信推送的http请求
+	Message *Message      // 推送的消息或事件
+	server  *Server
+	reply   []byte // 被动回复的XML
+}
+
+// Decode 把推送的原始XML解析到v，用于读取Message之外的字段
+func (c *Context) Decode(v interface{}) error {
+	return xml.Unmarshal(c.Message.Raw, v)
+}
+
+// TypedMessage 按MsgType和Event把推送解析为对应的结构体指针，如*TextMessage、*SubscribeEvent
+// 未知的消息类型和事件返回Message
+func (c *Context) TypedMessage() (TypedMessage, error) {
+	return decodeTypedMessage(c.Message)
+}
+
+// Reply 设置被动回复，不调用Reply时回复success
+// v为Reply时用MarshalReply生成XML，自动填写发送方、接收方和CreateTime，其他类型直接以XML发送给微信
+func (c *Context) Reply(v interface{}) error {
+	var data []byte
+	var err error
+	if r, ok := v.(Reply); ok {
+		data, err = MarshalReply(&c.Message.MessageHeader, r)
+	} else {
+		data, err = xml.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	c.reply = data
+	return nil
+}
+
+// ServerConfig 接收微信推送的配置
+type ServerConfig struct {
+	Token          string        // 公众平台服务器配置中的令牌Token
+	AppID          string        // 公众号AppID，安全模式下用于校验解密后的消息
+	EncodingAESKey string        // 消息加解密密钥，43位字符，为空时只支持明文模式
+	ReplyTimeout   time.Duration // 处理函数的时限，超时后回复success，为0时使用4.5秒，小于0时不限制
+	Logger         Logger        // 日志，为空时使用标准库log输出
+}
+
+// Server 接收微信推送的http.Handler
+// GET请求校验签名后返回echostr，完成服务器地址的验证
+// POST请求校验签名后解析消息，按MsgType或Event交给注册的MessageHandler，回复其设置的被动回复或success
+// 设置了EncodingAESKey时支持安全模式和兼容模式，encrypt_type=aes的推送会校验msg_signature并解密，被动回复也会加密
+type Server struct {
+	token          string
+	crypter        *MessageCrypter // 安全模式的加解密，明文模式为nil
+	replyTimeout   time.Duration
+	logger         Logger
+	mu             sync.RWMutex
+	msgHandlers    map[string]MessageHandler // 按MsgType注册的处理函数
+	eventHandlers  map[string]MessageHandler // 按Event注册的处理函数，key为小写
+	defaultHandler MessageHandler            // 没有对应处理函数时使用
+}
+
+// NewServer 创建接收微信推送的Server
+func NewServer(config *ServerConfig) (*Server, error) {
+	if len(config.Token) == 0 {
+		return nil, errors.New("Token不能为空")
+	}
+	s := &Server{
+		token:         config.Token,
+		replyTimeout:  config.ReplyTimeout,
+		logger:        config.Logger,
+		msgHandlers:   make(map[string]MessageHandler),
+		eventHandlers: make(map[string]MessageHandler),
+	}
+	if s.logger == nil {
+		s.logger = newStdLogger("")
+	}
+	if s.replyTimeout == 0 {
+		s.replyTimeout = defaultReplyTimeout
+	}
+	if len(config.EncodingAESKey) > 0 {
+		crypter, err := NewMessageCrypter(config.Token, config.EncodingAESKey, config.AppID)
+		if err != nil {
+			return nil, err
+		}
+		s.crypter = crypter
+	}
+	return s, nil
+}
+
+// HandleMessage 注册msgType类型消息的处理函数，如 MsgTypeText
+func (s *Server) HandleMessage(msgType string, h MessageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgHandlers[msgType] = h
+}
+
+// HandleEvent 注册event事件的处理函数，如 subscribe、CLICK，不区分大小写
+func (s *Server) HandleEvent(event string, h MessageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventHandlers[strings.ToLower(event)] = h
+}
+
+// HandleDefault 注册没有对应处理函数的消息和事件的处理函数
+func (s *Server) HandleDefault(h MessageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultHandler = h
+}
+
+// handler 返回消息对应的处理函数
+func (s *Server) handler(msg *Message) MessageHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var h MessageHandler
+	if msg.MsgType == MsgTypeEvent {
+		h = s.eventHandlers[strings.ToLower(msg.Event)]
+	} else {
+		h = s.msgHandlers[msg.MsgType]
+	}
+	if h == nil {
+		h = s.defaultHandler
+	}
+	return h
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !CheckNotify(query.Get("signature"), query.Get("timestamp"), query.Get("nonce"), s.token) {
+		s.logger.Warn("微信推送的签名无效", "remote", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		io.WriteString(w, query.Get("echostr"))
+	case "POST":
+		s.serveMessage(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveMessage 解析并分发一条推送，写入被动回复或success
+func (s *Server) serveMessage(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxNotifyBodySize))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	encrypted := query.Get("encrypt_type") == "aes"
+	if encrypted {
+		if body, err = s.decrypt(query, body); err != nil {
+			s.logger.Warn("无法解密微信推送", "error", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg := &Message{}
+	if err := xml.Unmarshal(body, msg); err != nil {
+		s.logger.Warn("无法解析微信推送", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	msg.Raw = body
+
+	reply, err := s.handle(r, msg)
+	if err != nil {
+		s.logger.Error("处理微信推送失败", "msg_type", msg.MsgType, "event", msg.Event, "error", err)
+		reply = nil
+	}
+
+	if len(reply) == 0 {
+		io.WriteString(w, "success")
+		return
+	}
+	if encrypted {
+		if reply, err = s.crypter.EncryptReply(reply, strconv.FormatInt(time.Now().Unix(), 10), query.Get("nonce")); err != nil {
+			s.logger.Error("加密被动回复失败", "error", err)
+			io.WriteString(w, "success")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(reply)
+}
+
+// handle 在replyTimeout内调用消息对应的处理函数，返回被动回复的XML
+// 超时后不再等待处理函数，其设置的被动回复会被丢弃，处理函数可以通过Request.Context()得知超时
+func (s *Server) handle(r *http.Request, msg *Message) ([]byte, error) {
+	h := s.handler(msg)
+	if h == nil {
+		return nil, nil
+	}
+	if s.replyTimeout < 0 {
+		c := &Context{Request: r, Message: msg, server: s}
+		err := h(c)
+		return c.reply, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.replyTimeout)
+	defer cancel()
+	c := &Context{Request: r.WithContext(ctx), Message: msg, server: s}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- errors.Errorf("处理函数panic %v", p)
+			}
+		}()
+		done <- h(c)
+	}()
+	select {
+	case err := <-done:
+		return c.reply, err
+	case <-ctx.Done():
+		return nil, errors.Errorf("处理函数没有在%s内返回", s.replyTimeout)
+	}
+}
+
+// decrypt 校验安全模式推送的msg_signature并解密，返回消息的XML
+// 兼容模式下推送同时包含明文字段和Encrypt，以解密的内容为准
+func (s *Server) decrypt(query url.Values, body []byte) ([]byte, error) {
+	if s.crypter == nil {
+		return nil, errors.New("收到安全模式的推送，但没有配置EncodingAESKey")
+	}
+	envelope := &encryptedEnvelope{}
+	if err := xml.Unmarshal(body, envelope); err != nil {
+		return nil, err
+	}
+	if len(envelope.Encrypt) == 0 {
+		return nil, errors.New("安全模式的推送没有Encrypt字段")
+	}
+	return s.crypter.Decrypt(query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce"), string(envelope.Encrypt))
+}
@@ -0,0 +1,183 @@
+package wechat
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notifyQuery 返回带有正确signature的推送地址参数
+func notifyQuery(token string) url.Values {
+	timestamp, nonce := "1409304348", "1320562132"
+	strs := []string{token, timestamp, nonce}
+	sort.Strings(strs)
+	return url.Values{
+		"signature": {fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(strs, ""))))},
+		"timestamp": {timestamp},
+		"nonce":     {nonce},
+	}
+}
+
+// serveNotify 向s发送一次推送，返回响应
+func serveNotify(s *Server, method string, query url.Values, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, "/wechat?"+query.Encode(), strings.NewReader(body)))
+	return rec
+}
+
+// TestServerVerify 配置服务器地址时，签名正确则返回echostr，签名错误返回403
+func TestServerVerify(t *testing.T) {
+	s, err := NewServer(&ServerConfig{Token: sampleToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := notifyQuery(sampleToken)
+	query.Set("echostr", "5838479218127813673")
+	if rec := serveNotify(s, "GET", query, ""); rec.Code != http.StatusOK || rec.Body.String() != "5838479218127813673" {
+		t.Fatalf("应返回echostr，实际返回 %d %s", rec.Code, rec.Body)
+	}
+
+	query.Set("signature", strings.Repeat("0", 40))
+	if rec := serveNotify(s, "GET", query, ""); rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "5838479218127813673") {
+		t.Fatalf("签名错误应返回403，实际返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := serveNotify(s, "POST", query, `<xml><MsgType><![CDATA[text]]></MsgType></xml>`); rec.Code != http.StatusForbidden {
+		t.Fatalf("签名错误的推送应返回403，实际返回 %d", rec.Code)
+	}
+}
+
+// TestServerDispatch 按MsgType和Event分发推送，Event不区分大小写，没有对应处理函数时交给默认处理函数
+func TestServerDispatch(t *testing.T) {
+	s, err := NewServer(&ServerConfig{Token: sampleToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replyWith := func(content string) MessageHandler {
+		return func(c *Context) error {
+			return c.Reply(TextReply{Content: content})
+		}
+	}
+	s.HandleMessage(MsgTypeText, replyWith("text"))
+	s.HandleEvent("CLICK", replyWith("click"))
+	s.HandleEvent("subscribe", func(c *Context) error { return nil })
+
+	tests := []struct {
+		name string
+		body string
+		want string // 被动回复的Content，为空表示回复success
+	}{
+		{"文本消息", `<xml><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[你好]]></Content></xml>`, "text"},
+		{"事件", `<xml><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[click]]></Event></xml>`, "click"},
+		{"不回复的事件", `<xml><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[subscribe]]></Event></xml>`, ""},
+		{"没有处理函数", `<xml><MsgType><![CDATA[image]]></MsgType></xml>`, ""},
+	}
+	check := func() {
+		t.Helper()
+		for _, tt := range tests {
+			rec := serveNotify(s, "POST", notifyQuery(sampleToken), tt.body)
+			if tt.want == "" {
+				if rec.Body.String() != "success" {
+					t.Errorf("%s: 应回复success，实际回复 %s", tt.name, rec.Body)
+				}
+				continue
+			}
+			reply := &TextMessage{}
+			if err := xml.Unmarshal(rec.Body.Bytes(), reply); err != nil || reply.Content != tt.want {
+				t.Errorf("%s: 应回复 %s，实际回复 %s", tt.name, tt.want, rec.Body)
+			}
+		}
+	}
+	check()
+
+	s.HandleDefault(replyWith("default"))
+	tests[3].want = "default"
+	check()
+}
+
+// TestServerEncrypted 安全模式的推送校验msg_signature后解密，被动回复加密后返回
+func TestServerEncrypted(t *testing.T) {
+	s, err := NewServer(&ServerConfig{Token: sampleToken, AppID: sampleAppID, EncodingAESKey: sampleEncodingAESKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleMessage(MsgTypeText, func(c *Context) error {
+		msg, err := c.TypedMessage()
+		if err != nil {
+			return err
+		}
+		return c.Reply(TextReply{Content: "收到 " + msg.(*TextMessage).Content})
+	})
+
+	c := newSampleCrypter(t, sampleAppID)
+	query := notifyQuery(sampleToken)
+	msg := `<xml><ToUserName><![CDATA[gh_7f083739789a]]></ToUserName><FromUserName><![CDATA[oia2TjjewbmiOUlr6X-1crbLOvLw]]></FromUserName><CreateTime>1407743423</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[你好]]></Content></xml>`
+	body, err := c.EncryptReply([]byte(msg), query.Get("timestamp"), query.Get("nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := &encryptedEnvelope{}
+	if err := xml.Unmarshal(body, envelope); err != nil {
+		t.Fatal(err)
+	}
+	query.Set("encrypt_type", "aes")
+	query.Set("msg_signature", string(envelope.MsgSignature))
+
+	rec := serveNotify(s, "POST", query, string(body))
+	reply := &encryptedEnvelope{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), reply); err != nil {
+		t.Fatalf("应返回加密的被动回复，实际返回 %s", rec.Body)
+	}
+	if string(reply.Nonce) != query.Get("nonce") {
+		t.Fatalf("被动回复的Nonce为 %s，应与推送相同", reply.Nonce)
+	}
+	plain, err := c.Decrypt(string(reply.MsgSignature), reply.TimeStamp, string(reply.Nonce), string(reply.Encrypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := &TextMessage{}
+	if err := xml.Unmarshal(plain, text); err != nil {
+		t.Fatal(err)
+	}
+	if text.Content != "收到 你好" || text.ToUserName != "oia2TjjewbmiOUlr6X-1crbLOvLw" || text.FromUserName != "gh_7f083739789a" {
+		t.Fatalf("被动回复不正确 %s", plain)
+	}
+
+	query.Set("msg_signature", strings.Repeat("0", 40))
+	if rec := serveNotify(s, "POST", query, string(body)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("msg_signature错误应返回400，实际返回 %d", rec.Code)
+	}
+}
+
+// TestServerReplyTimeout 处理函数超时或panic时回复success，超时后设置的被动回复被丢弃
+func TestServerReplyTimeout(t *testing.T) {
+	s, err := NewServer(&ServerConfig{Token: sampleToken, ReplyTimeout: time.Millisecond * 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replied := make(chan error, 1)
+	s.HandleMessage(MsgTypeText, func(c *Context) error {
+		<-c.Request.Context().Done()
+		replied <- c.Reply(TextReply{Content: "太晚了"})
+		return nil
+	})
+	s.HandleMessage(MsgTypeImage, func(c *Context) error {
+		panic("处理图片消息出错")
+	})
+
+	if rec := serveNotify(s, "POST", notifyQuery(sampleToken), `<xml><MsgType><![CDATA[text]]></MsgType></xml>`); rec.Body.String() != "success" {
+		t.Fatalf("处理函数超时应回复success，实际回复 %s", rec.Body)
+	}
+	if err := <-replied; err != nil {
+		t.Fatal(err)
+	}
+	if rec := serveNotify(s, "POST", notifyQuery(sampleToken), `<xml><MsgType><![CDATA[image]]></MsgType></xml>`); rec.Body.String() != "success" {
+		t.Fatalf("处理函数panic应回复success，实际回复 %s", rec.Body)
+	}
+}
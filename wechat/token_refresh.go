@@ -0,0 +1,237 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	durationRefreshAhead  = time.Minute * 5  // 自治策略下，在AccessToken过期前多久开始刷新
+	durationRefreshJitter = time.Minute * 2  // 刷新时间的随机抖动，避免共享TokenStore的多个进程同时刷新
+	durationRefreshRetry  = time.Second * 30 // 刷新失败后的重试间隔
+
+	durationForceRefreshInterval = time.Second * 30 // 两次强制刷新之间的最小间隔，与微信stable_token的限制一致
+	maxForceRefreshPerDay        = 20               // 每天最多强制刷新的次数，与微信stable_token的限制一致
+
+	maxTokenInvalidRetries = 2 // 因AccessToken失效而刷新并重新发送请求的最大次数
+)
+
+// ErrForceRefreshLimited 强制刷新AccessToken过于频繁
+var ErrForceRefreshLimited = errors.New("强制刷新AccessToken过于频繁，需间隔30秒且每天不超过20次")
+
+type contextKey int
+
+const (
+	ctxKeyNoTokenCheck contextKey = iota // 请求返回AccessToken失效的错误码时，不触发失效处理
+	ctxKeyRetrySafe                      // 请求重复发送没有副作用，遇到临时故障时可以重试
+)
+
+// startTimer 启动自治维护AccessToken的timer
+// 根据TokenStore中AccessToken的过期时间，在过期前 durationRefreshAhead 加上随机抖动的时间点刷新，
+// 不再轮询验证AccessToken是否有效
+func (w *APIClient) startTimer() {
+	next := w.refreshIfDue()
+	w.timerMu.Lock()
+	defer w.timerMu.Unlock()
+	if w.closed {
+		return
+	}
+	w.timer = time.AfterFunc(next, w.startTimer)
+}
+
+// refreshIfDue AccessToken将在 durationRefreshAhead 内过期时刷新，返回距下一次需要刷新的时间
+func (w *APIClient) refreshIfDue() time.Duration {
+	key := w.accessTokenKey()
+	_, expireAt, err := w.tokenStore.Get(key)
+	if err != nil || !time.Now().Add(durationRefreshAhead).Before(expireAt) {
+		if _, err = w.refreshAccessToken(context.Background(), "", durationRefreshAhead); err != nil {
+			w.logger.Error("刷新AccessToken失败", "appid", w.AppID, "error", err)
+		} else {
+			_, expireAt, err = w.tokenStore.Get(key)
+		}
+	}
+
+	next := durationRefreshRetry
+	if err == nil {
+		next = time.Until(expireAt) - durationRefreshAhead - time.Duration(rand.Int63n(int64(durationRefreshJitter)))
+		if next < durationRefreshRetry {
+			next = durationRefreshRetry
+		}
+	}
+	return next
+}
+
+// ForceRefreshAccessToken 强制刷新AccessToken并保存到TokenStore
+// 使用稳定版AccessToken时以force_refresh模式请求微信，http策略下要求中控服务器强制刷新
+// 为遵守微信的频率限制，两次强制刷新需间隔30秒且每天不超过20次，超出时返回ErrForceRefreshLimited
+func (w *APIClient) ForceRefreshAccessToken() (string, error) {
+	return w.ForceRefreshAccessTokenContext(context.Background())
+}
+
+// ForceRefreshAccessTokenContext 与ForceRefreshAccessToken相同，通过ctx控制请求的超时与取消
+func (w *APIClient) ForceRefreshAccessTokenContext(ctx context.Context) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !w.forceLimiter.Allow(time.Now()) {
+		return "", ErrForceRefreshLimited
+	}
+
+	key := w.accessTokenKey()
+	unlock, err := w.tokenStore.Lock(key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	now := time.Now()
+	previous, _, _ := w.tokenStore.Get(key)
+	token, expiresIn, err := w.fetchAccessToken(ctx, true, "")
+	if err != nil {
+		return "", err
+	}
+	w.storeAccessToken(key, previous, token, now.Add(time.Duration(expiresIn)*time.Second))
+	return token, nil
+}
+
+// ForceRefreshLimiter 强制刷新AccessToken的频率限制，两次需间隔30秒且每天不超过20次，与微信stable_token的限制一致
+// 零值可以直接使用，中控服务器也用它限制客户端要求的强制刷新
+type ForceRefreshLimiter struct {
+	mu    sync.Mutex
+	last  time.Time // 上一次强制刷新的时间
+	day   string    // count对应的日期，与微信一样按北京时间计算
+	count int       // 当天强制刷新的次数
+}
+
+// Allow 判断现在能否强制刷新，能则记录一次
+func (l *ForceRefreshLimiter) Allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.last) < durationForceRefreshInterval {
+		return false
+	}
+	day := now.In(beijing).Format("2006-01-02")
+	if day != l.day {
+		l.day, l.count = day, 0
+	}
+	if l.count >= maxForceRefreshPerDay {
+		return false
+	}
+	l.last = now
+	l.count++
+	return true
+}
+
+// handleTokenInvalid 微信返回AccessToken失效的错误码时调用，
+// 用Verify确认请求中携带的AccessToken确实已失效后，立即刷新，返回刷新后的AccessToken
+// 只处理本APIClient从TokenStore取出的公众号AccessToken，网页授权的用户AccessToken等其他token失效时不刷新，
+// 避免把公众号的AccessToken发送到/sns/等按用户授权的接口
+func (w *APIClient) handleTokenInvalid(ctx context.Context, req *http.Request) (string, bool) {
+	if ctx.Value(ctxKeyNoTokenCheck) != nil {
+		return "", false
+	}
+	if w.accessTokenCachePolicy == CachePolicyNone && !w.stableAccessToken {
+		return "", false
+	}
+	if strings.Contains(req.URL.Path, "/sns/") {
+		return "", false
+	}
+	token := req.URL.Query().Get("access_token")
+	if len(token) == 0 || !w.ownsAccessToken(token) || w.AccessToken.VerifyContext(ctx, token) {
+		return "", false
+	}
+	fresh, err := w.refreshAccessToken(ctx, token, durationTokenExpireAhead)
+	if err != nil {
+		w.logger.Error("AccessToken已失效，刷新失败", "appid", w.AppID, "error", err)
+		return "", false
+	}
+	return fresh, fresh != token
+}
+
+// storeAccessToken 把token保存到TokenStore，并记录被替换的previous
+func (w *APIClient) storeAccessToken(key, previous, token string, expireAt time.Time) {
+	if err := w.tokenStore.Set(key, token, expireAt); err != nil {
+		w.logger.Error("保存AccessToken到TokenStore异常", "error", err)
+	}
+	if len(previous) > 0 && previous != token {
+		w.retiredMu.Lock()
+		w.retiredToken = previous
+		w.retiredMu.Unlock()
+	}
+}
+
+// ownsAccessToken 判断token是否为TokenStore中当前的AccessToken，或本APIClient最近一次刷新替换掉的AccessToken
+// 并发的请求可能在刷新前取出了旧的AccessToken，刷新后它们返回失效时仍应使用新的AccessToken重试
+func (w *APIClient) ownsAccessToken(token string) bool {
+	if stored, _, err := w.tokenStore.Get(w.accessTokenKey()); err == nil && stored == token {
+		return true
+	}
+	w.retiredMu.Lock()
+	defer w.retiredMu.Unlock()
+	return w.retiredToken == token
+}
+
+// withAccessToken 复制请求，并将其中的access_token参数替换为token
+func withAccessToken(req *http.Request, token string) (*http.Request, error) {
+	retryReq, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	query := retryReq.URL.Query()
+	query.Set("access_token", token)
+	retryReq.URL.RawQuery = query.Encode()
+	return retryReq, nil
+}
+
+// flightGroup 合并相同key的并发调用，同一时间每个key只有一次调用在执行
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// flight 一次正在执行的调用
+type flight struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// do 在新的goroutine中执行fn，若相同key的调用正在执行，则等待其完成并返回其结果
+// fn的结果由所有调用方共享，不受任何一个调用方取消的影响，每个调用方只在自己的ctx结束时提前返回
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		c = &flight{done: make(chan struct{})}
+		g.calls[key] = c
+		go func() {
+			c.token, c.err = fn()
+			close(c.done)
+
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.token, c.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// withoutTokenCheck 返回的context发起的请求，不会因为AccessToken失效的错误码触发刷新
+func withoutTokenCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyNoTokenCheck, true)
+}
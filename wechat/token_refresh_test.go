@@ -0,0 +1,162 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestGetAccessTokenConcurrent 并发获取AccessToken、自治刷新和Close同时进行时，没有数据竞争，且只向微信请求一次AccessToken
+func TestGetAccessTokenConcurrent(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cgi-bin/token" {
+			http.NotFound(rw, r)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond * 50) // 让并发的调用都等待同一次刷新
+		fmt.Fprint(rw, `{"access_token":"ACCESS_TOKEN","expires_in":7200}`)
+	}))
+	defer srv.Close()
+
+	w, err := NewClient(&APIConfig{
+		AppID:                  "wx1234567890abcdef",
+		AppSecret:              "0123456789abcdef0123456789abcdef",
+		AccessTokenCachePolicy: CachePolicyAutonomy,
+	}, WithBaseURL(srv.URL), func(w *APIClient) error {
+		w.sharedRefresh = true // 由测试启动timer，使其与GetAccessToken并发
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := w.GetAccessToken()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if token != "ACCESS_TOKEN" {
+				t.Errorf("GetAccessToken返回 %s", token)
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w.startTimer()
+	}()
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond * 10)
+		w.Close()
+	}()
+	wg.Wait()
+	w.Close()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("/cgi-bin/token 被请求了%d次，应只请求1次", n)
+	}
+	w.timerMu.Lock()
+	defer w.timerMu.Unlock()
+	if !w.closed {
+		t.Fatal("Close之后closed应为true")
+	}
+}
+
+// TestForceRefreshLimiterBeijingDay 强制刷新的次数在北京时间0点重置，与服务器所在时区无关
+func TestForceRefreshLimiterBeijingDay(t *testing.T) {
+	var l ForceRefreshLimiter
+	// UTC 16:00 已是北京时间次日0点，UTC 15:59 仍是北京时间前一天
+	start := time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC)
+	now := start
+	for i := 0; i < maxForceRefreshPerDay; i++ {
+		if !l.Allow(now) {
+			t.Fatalf("第%d次强制刷新被拒绝", i+1)
+		}
+		now = now.Add(durationForceRefreshInterval)
+	}
+	if l.Allow(time.Date(2024, 1, 1, 15, 59, 0, 0, time.UTC)) {
+		t.Fatal("北京时间当天超过次数限制后仍允许强制刷新")
+	}
+	if !l.Allow(time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC)) {
+		t.Fatal("北京时间0点后应重新计数")
+	}
+}
+
+// TestRefreshAccessTokenCallerCanceled 发起刷新的调用方取消后，刷新继续进行，等待同一次刷新的其他调用方仍能拿到AccessToken
+func TestRefreshAccessTokenCallerCanceled(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	srv := newTestServer(t, func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cgi-bin/token" {
+			http.NotFound(rw, r)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(rw, `{"access_token":"ACCESS_TOKEN","expires_in":7200}`)
+	})
+	w, err := NewClient(&APIConfig{
+		AppID:                  "wx1234567890abcdef",
+		AppSecret:              "0123456789abcdef0123456789abcdef",
+		AccessTokenCachePolicy: CachePolicyAutonomy,
+	}, WithBaseURL(srv.URL), func(w *APIClient) error {
+		w.sharedRefresh = true // 不启动timer，只由GetAccessTokenContext发起刷新
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := w.GetAccessTokenContext(ctx)
+		first <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	type result struct {
+		token string
+		err   error
+	}
+	second := make(chan result, 1)
+	go func() {
+		token, err := w.GetAccessTokenContext(context.Background())
+		second <- result{token, err}
+	}()
+	time.Sleep(time.Millisecond * 20) // 让第二个调用方加入同一次刷新
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("取消的调用方应返回context.Canceled，实际返回 %v", err)
+	}
+	close(release)
+	r := <-second
+	if r.err != nil || r.token != "ACCESS_TOKEN" {
+		t.Fatalf("等待刷新的调用方应拿到AccessToken，实际返回 %s %v", r.token, r.err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("/cgi-bin/token 被请求了%d次，应只请求1次", n)
+	}
+}
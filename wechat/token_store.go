@@ -0,0 +1,230 @@
+package wechat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	durationFileLockRetry = time.Millisecond * 50 // 文件锁被占用时的重试间隔
+	durationFileLockStale = time.Minute           // 文件锁超过这个时间未释放，视为持有者已崩溃
+)
+
+// ErrTokenNotFound TokenStore中没有对应的token
+var ErrTokenNotFound = errors.New("token不存在")
+
+// TokenStore AccessToken的存储，APIClient在所有缓存策略下都通过TokenStore读写AccessToken
+// 多个进程共享同一个TokenStore时，可以共用同一个AccessToken，重启也不会丢失
+// 自定义的TokenStore可以用 tokenstoretest.Run 测试是否满足要求，锁需要由等待者接管时再用 tokenstoretest.RunStaleLock 测试
+type TokenStore interface {
+	// Get 获取key对应的token及其过期时间，不存在时返回ErrTokenNotFound
+	Get(key string) (token string, expireAt time.Time, err error)
+	// Set 保存key对应的token及其过期时间
+	Set(key, token string, expireAt time.Time) error
+	// Lock 获取刷新key对应token的锁，同一时间只有一个持有者可以刷新token，调用返回的unlock释放锁
+	Lock(key string) (unlock func(), err error)
+}
+
+// storedToken TokenStore中保存的一条token
+type storedToken struct {
+	Token    string    `json:"token"`
+	ExpireAt time.Time `json:"expire_at"`
+}
+
+// MemoryTokenStore 进程内存中的TokenStore，进程重启后token丢失
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]storedToken
+	locks  map[string]*sync.Mutex
+}
+
+// NewMemoryTokenStore 创建一个内存TokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]storedToken),
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+// Get 获取key对应的token及其过期时间
+func (s *MemoryTokenStore) Get(key string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[key]
+	if !ok {
+		return "", time.Time{}, ErrTokenNotFound
+	}
+	return t.Token, t.ExpireAt, nil
+}
+
+// Set 保存key对应的token及其过期时间
+func (s *MemoryTokenStore) Set(key, token string, expireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = storedToken{Token: token, ExpireAt: expireAt}
+	return nil
+}
+
+// Lock 获取刷新key对应token的锁
+func (s *MemoryTokenStore) Lock(key string) (func(), error) {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[key] = l
+	}
+	s.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock, nil
+}
+
+// FileTokenStore 以文件保存token的TokenStore，每个key保存为目录下的一个json文件
+// 同一台机器上的多个进程可以共享同一个目录，进程重启后token不会丢失
+type FileTokenStore struct {
+	dir string
+}
+
+// NewFileTokenStore 创建一个以dir目录保存token的TokenStore
+func NewFileTokenStore(dir string) (*FileTokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{dir: dir}, nil
+}
+
+// Get 获取key对应的token及其过期时间
+func (s *FileTokenStore) Get(key string) (string, time.Time, error) {
+	data, err := ioutil.ReadFile(s.path(key, ".json"))
+	if os.IsNotExist(err) {
+		return "", time.Time{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	t := storedToken{}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return "", time.Time{}, err
+	}
+	return t.Token, t.ExpireAt, nil
+}
+
+// Set 保存key对应的token及其过期时间，先写临时文件再重命名，避免其他进程读到写了一半的文件
+func (s *FileTokenStore) Set(key, token string, expireAt time.Time) error {
+	data, err := json.Marshal(storedToken{Token: token, ExpireAt: expireAt})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, ".token-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key, ".json"))
+}
+
+// Lock 以独占创建锁文件的方式获取刷新key对应token的锁，锁文件中写入本次持有者的ID
+// 锁文件长时间未释放时视为持有者已崩溃，由等待者接管；释放时只删除仍属于自己的锁文件
+func (s *FileTokenStore) Lock(key string) (func(), error) {
+	lockPath := s.path(key, ".lock")
+	id, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err := createLockFile(lockPath, id)
+		if err == nil {
+			return func() {
+				if owner, err := ioutil.ReadFile(lockPath); err == nil && string(owner) == id {
+					os.Remove(lockPath)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if takeOverStaleLock(lockPath, id) {
+			continue
+		}
+		time.Sleep(durationFileLockRetry)
+	}
+}
+
+// newLockOwner 生成锁文件持有者的随机ID
+func newLockOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createLockFile 独占创建锁文件并写入持有者的ID，锁文件已存在时返回的错误满足os.IsExist
+func createLockFile(lockPath, id string) error {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(id)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lockPath)
+	}
+	return err
+}
+
+// takeOverStaleLock 锁文件已过期时把它改名移走，移走成功返回true，调用方随后重新争抢锁
+// 多个等待者可能同时发现锁过期，改名是原子的，只有一个能移走过期的锁文件；
+// 其他等待者移走的若是刚被重新创建的锁文件，则放回原处
+func takeOverStaleLock(lockPath, id string) bool {
+	if !isStaleLock(lockPath) {
+		return false
+	}
+	moved := lockPath + "." + id
+	if err := os.Rename(lockPath, moved); err != nil {
+		return false
+	}
+	if isStaleLock(moved) {
+		os.Remove(moved)
+		return true
+	}
+	os.Link(moved, lockPath)
+	os.Remove(moved)
+	return false
+}
+
+// isStaleLock 判断锁文件是否超过durationFileLockStale未释放
+func isStaleLock(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) > durationFileLockStale
+}
+
+// path 返回key对应的文件路径，key中的路径分隔符等字符会被替换
+func (s *FileTokenStore) path(key, ext string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, key)
+	return filepath.Join(s.dir, name+ext)
+}
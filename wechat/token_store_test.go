@@ -0,0 +1,41 @@
+package wechat_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/meikeland/go-wechat/wechat"
+	"github.com/meikeland/go-wechat/wechat/tokenstoretest"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	tokenstoretest.Run(t, func(t *testing.T) wechat.TokenStore {
+		return wechat.NewMemoryTokenStore()
+	})
+}
+
+func TestFileTokenStore(t *testing.T) {
+	var dir string // 最近一次创建的FileTokenStore的目录
+	newStore := func(t *testing.T) wechat.TokenStore {
+		dir = t.TempDir()
+		store, err := wechat.NewFileTokenStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	tokenstoretest.Run(t, newStore)
+	tokenstoretest.RunStaleLock(t, newStore, func(t *testing.T, store wechat.TokenStore, key string) {
+		// 锁文件的修改时间改到一小时前，模拟持有者已崩溃
+		locks, err := filepath.Glob(filepath.Join(dir, "*.lock"))
+		if err != nil || len(locks) != 1 {
+			t.Fatalf("应有1个锁文件，实际为 %v %v", locks, err)
+		}
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(locks[0], old, old); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+// Package tokenstoretest 提供 wechat.TokenStore 的通用行为测试
+// 内置的MemoryTokenStore、FileTokenStore都通过这组测试，基于Redis等外部KV存储的TokenStore
+// 可以在测试中连接本地的替身服务（如miniredis）运行同一组测试，确认满足APIClient的要求
+package tokenstoretest
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meikeland/go-wechat/wechat"
+)
+
+const lockTimeout = time.Second * 5 // 等待获取锁的最长时间
+
+// Run 对newStore创建的TokenStore运行通用行为测试，每个子测试都调用newStore创建新的TokenStore
+func Run(t *testing.T, newStore func(t *testing.T) wechat.TokenStore) {
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newStore(t)) })
+	t.Run("SetGet", func(t *testing.T) { testSetGet(t, newStore(t)) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newStore(t)) })
+	t.Run("Expired", func(t *testing.T) { testExpired(t, newStore(t)) })
+	t.Run("LockExclusive", func(t *testing.T) { testLockExclusive(t, newStore(t)) })
+	t.Run("LockPerKey", func(t *testing.T) { testLockPerKey(t, newStore(t)) })
+	t.Run("LockConcurrent", func(t *testing.T) { testLockConcurrent(t, newStore(t)) })
+}
+
+// RunStaleLock 测试过期锁的接管，适用于持有者崩溃后锁不会自动释放、需要由等待者接管的TokenStore
+// expire把key当前的锁标记为已过期，如FileTokenStore把锁文件的修改时间改到过期之前
+func RunStaleLock(t *testing.T, newStore func(t *testing.T) wechat.TokenStore, expire func(t *testing.T, store wechat.TokenStore, key string)) {
+	t.Run("TakeOver", func(t *testing.T) { testTakeOver(t, newStore(t), expire) })
+	t.Run("TakeOverConcurrent", func(t *testing.T) { testTakeOverConcurrent(t, newStore(t), expire) })
+}
+
+// testNotFound 不存在的key返回ErrTokenNotFound
+func testNotFound(t *testing.T, store wechat.TokenStore) {
+	if _, _, err := store.Get("access_token:missing"); !errors.Is(err, wechat.ErrTokenNotFound) {
+		t.Fatalf("Get不存在的key应返回ErrTokenNotFound，实际返回 %v", err)
+	}
+}
+
+// testSetGet Set之后Get返回相同的token和过期时间，不同的key互不影响
+func testSetGet(t *testing.T, store wechat.TokenStore) {
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.Set("access_token:a", "token-a", expireAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("access_token:b", "token-b", expireAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertToken(t, store, "access_token:a", "token-a", expireAt)
+	assertToken(t, store, "access_token:b", "token-b", expireAt.Add(time.Minute))
+}
+
+// testOverwrite 再次Set覆盖之前的token
+func testOverwrite(t *testing.T, store wechat.TokenStore) {
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.Set("access_token:a", "old", expireAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("access_token:a", "new", expireAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertToken(t, store, "access_token:a", "new", expireAt.Add(time.Hour))
+}
+
+// testExpired 已过期的token可以原样返回由APIClient判断，也可以由存储自动删除而返回ErrTokenNotFound
+func testExpired(t *testing.T, store wechat.TokenStore) {
+	expireAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := store.Set("access_token:a", "expired", expireAt); err != nil {
+		t.Fatal(err)
+	}
+	token, got, err := store.Get("access_token:a")
+	if errors.Is(err, wechat.ErrTokenNotFound) {
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "expired" || !got.Equal(expireAt) {
+		t.Fatalf("过期的token应原样返回或返回ErrTokenNotFound，实际返回 %s %v", token, got)
+	}
+}
+
+// testLockExclusive 同一个key的锁在释放前不能被再次获取
+func testLockExclusive(t *testing.T, store wechat.TokenStore) {
+	unlock, err := store.Lock("access_token:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := lockAsync(t, store, "access_token:a")
+	select {
+	case <-acquired:
+		t.Fatal("锁未释放时被再次获取")
+	case <-time.After(time.Millisecond * 200):
+	}
+	unlock()
+
+	select {
+	case unlock, ok := <-acquired:
+		if ok {
+			unlock()
+		}
+	case <-time.After(lockTimeout):
+		t.Fatal("锁释放后无法再次获取")
+	}
+}
+
+// testLockPerKey 不同key的锁互不影响
+func testLockPerKey(t *testing.T, store wechat.TokenStore) {
+	unlockA, err := store.Lock("access_token:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlockA()
+
+	acquired := make(chan error, 1)
+	go func() {
+		unlockB, err := store.Lock("access_token:b")
+		if err == nil {
+			unlockB()
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(lockTimeout):
+		t.Fatal("持有一个key的锁时，无法获取另一个key的锁")
+	}
+}
+
+// testLockConcurrent 多个goroutine同时争抢同一个key的锁，同一时间只有一个持有者
+func testLockConcurrent(t *testing.T, store wechat.TokenStore) {
+	if n := lockConcurrently(t, store, "access_token:a"); n != 1 {
+		t.Fatalf("同一时间有%d个锁的持有者", n)
+	}
+}
+
+// testTakeOver 过期的锁可以被接管，原持有者之后释放锁时不影响新的持有者
+func testTakeOver(t *testing.T, store wechat.TokenStore, expire func(t *testing.T, store wechat.TokenStore, key string)) {
+	unlockStale, err := store.Lock("access_token:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire(t, store, "access_token:a")
+
+	var unlock func()
+	select {
+	case unlock = <-lockAsync(t, store, "access_token:a"):
+	case <-time.After(lockTimeout):
+		t.Fatal("无法接管过期的锁")
+	}
+	if unlock == nil {
+		return
+	}
+	unlockStale()
+
+	acquired := lockAsync(t, store, "access_token:a")
+	select {
+	case <-acquired:
+		t.Fatal("原持有者释放锁时释放了接管者的锁")
+	case <-time.After(time.Millisecond * 200):
+	}
+	unlock()
+
+	select {
+	case unlock, ok := <-acquired:
+		if ok {
+			unlock()
+		}
+	case <-time.After(lockTimeout):
+		t.Fatal("接管者释放锁后无法再次获取")
+	}
+}
+
+// testTakeOverConcurrent 多个goroutine同时发现锁过期，只有一个能接管，同一时间只有一个持有者
+func testTakeOverConcurrent(t *testing.T, store wechat.TokenStore, expire func(t *testing.T, store wechat.TokenStore, key string)) {
+	if _, err := store.Lock("access_token:a"); err != nil {
+		t.Fatal(err)
+	}
+	expire(t, store, "access_token:a")
+	if n := lockConcurrently(t, store, "access_token:a"); n != 1 {
+		t.Fatalf("同一时间有%d个锁的持有者", n)
+	}
+}
+
+// lockConcurrently 多个goroutine同时争抢key的锁，每个持有一小段时间后释放，返回同一时间持有者数量的最大值
+func lockConcurrently(t *testing.T, store wechat.TokenStore, key string) int32 {
+	t.Helper()
+	const workers = 8
+	var holders, maxHolders int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := store.Lock(key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&holders, 1)
+			for {
+				max := atomic.LoadInt32(&maxHolders)
+				if n <= max || atomic.CompareAndSwapInt32(&maxHolders, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 5)
+			atomic.AddInt32(&holders, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	return maxHolders
+}
+
+// lockAsync 在新的goroutine中获取key的锁，获取到后把释放函数发送到返回的channel，出错时关闭channel
+func lockAsync(t *testing.T, store wechat.TokenStore, key string) <-chan func() {
+	acquired := make(chan func(), 1)
+	go func() {
+		unlock, err := store.Lock(key)
+		if err != nil {
+			t.Error(err)
+			close(acquired)
+			return
+		}
+		acquired <- unlock
+	}()
+	return acquired
+}
+
+// assertToken 断言key对应的token和过期时间
+func assertToken(t *testing.T, store wechat.TokenStore, key, token string, expireAt time.Time) {
+	t.Helper()
+	got, gotExpireAt, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s 返回错误 %v", key, err)
+	}
+	if got != token || !gotExpireAt.Equal(expireAt) {
+		t.Fatalf("Get %s 应返回 %s %v，实际返回 %s %v", key, token, expireAt, got, gotExpireAt)
+	}
+}
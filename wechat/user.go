@@ -1,12 +1,15 @@
 package wechat
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // UserService 处理与用户相关的API，包括用户授权登录和获取、更新用户资料
 type UserService service
 
 const (
-	urlGetUserInfo = "https://api.weixin.qq.com/cgi-bin/user/info?access_token=%s&openid=%s&lang=zh_CN"
+	urlGetUserInfo = "cgi-bin/user/info?access_token=%s&openid=%s&lang=zh_CN"
 )
 
 // WXUserInfo 微信用户基本信息
@@ -29,7 +32,12 @@ type WXUserInfo struct {
 
 // GetUserInfoByOpenid 通过openid获取用户基本信息
 func (s *UserService) GetUserInfoByOpenid(openid string) (*WXUserInfo, error) {
-	token, err := s.wechat.GetAccessToken()
+	return s.GetUserInfoByOpenidContext(context.Background(), openid)
+}
+
+// GetUserInfoByOpenidContext 与GetUserInfoByOpenid相同，通过ctx控制请求的超时与取消
+func (s *UserService) GetUserInfoByOpenidContext(ctx context.Context, openid string) (*WXUserInfo, error) {
+	token, err := s.wechat.GetAccessTokenContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +47,7 @@ func (s *UserService) GetUserInfoByOpenid(openid string) (*WXUserInfo, error) {
 		return nil, err
 	}
 	user := &WXUserInfo{}
-	_, err = s.wechat.Do(nil, req, user)
+	_, err = s.wechat.Do(ctx, req, user)
 	if err != nil {
 		return nil, err
 	}
@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/gotit/errors"
@@ -22,55 +21,150 @@ const (
 	CachePolicyAutonomy = "autonomy"
 	// CachePolicyHTTP 缓存AccessToken的策略为http，不自己维护AccessToken，每次需要，发送http请求到中控服务器获取
 	CachePolicyHTTP = "http"
+
+	defaultBaseURL     = "https://api.weixin.qq.com/"     // 微信公众平台接口地址
+	defaultMchBaseURL  = "https://api.mch.weixin.qq.com/" // 微信商户平台接口地址
+	defaultOpenBaseURL = "https://open.weixin.qq.com/"    // 微信网页授权页面地址
+	defaultTimeout     = time.Second * 30                 // 每次调用接口的默认超时时间
 )
 
-// APIConfig 调用微信Api的配置参数
+// APIConfig 调用微信Api的配置参数，可以通过LoadConfigFromEnv或LoadConfigFile从环境变量或配置文件加载
 type APIConfig struct {
-	AppID                   string // 公众号AppID
-	AppSecret               string // 公众号AppSecret
-	MchID                   string // 商户ID
-	MchSecret               string // 商户Secret
-	MemberCardID            string // 会员卡ID
-	AccessTokenCachePolicy  string // 公众号AccessToken缓存策略
-	AccessTokenCacheAddress string // 公众号AccessToken缓存地址，当APIClient需要用到AccessToken时，会去这个地址获取，只有在policy是http的情况下有用到
+	AppID                   string     `json:"app_id" yaml:"app_id"`                                         // 公众号AppID
+	OriginalID              string     `json:"original_id" yaml:"original_id"`                               // 公众号原始ID，gh_开头，即消息的ToUserName，Registry据此路由
+	AppSecret               string     `json:"app_secret" yaml:"app_secret"`                                 // 公众号AppSecret
+	MchID                   string     `json:"mch_id" yaml:"mch_id"`                                         // 商户ID
+	MchSecret               string     `json:"mch_secret" yaml:"mch_secret"`                                 // 商户Secret
+	MemberCardID            string     `json:"member_card_id" yaml:"member_card_id"`                         // 会员卡ID
+	AccessTokenCachePolicy  string     `json:"access_token_cache_policy" yaml:"access_token_cache_policy"`   // 公众号AccessToken缓存策略
+	AccessTokenCacheAddress string     `json:"access_token_cache_address" yaml:"access_token_cache_address"` // 公众号AccessToken缓存地址，当APIClient需要用到AccessToken时，会去这个地址获取，只有在policy是http的情况下有用到
+	AccessTokenCacheSecret  string     `json:"access_token_cache_secret" yaml:"access_token_cache_secret"`   // 访问中控服务器的共享密钥，只有在policy是http的情况下有用到
+	AccessTokenStable       bool       `json:"access_token_stable" yaml:"access_token_stable"`               // 是否使用稳定版接口 /cgi-bin/stable_token 获取AccessToken，不会使其他持有者的AccessToken失效
+	TokenStore              TokenStore `json:"-" yaml:"-"`                                                   // 公众号AccessToken的存储，为空时使用内存存储
+	TokenStoreDir           string     `json:"token_store_dir" yaml:"token_store_dir"`                       // TokenStore为空时，若设置了该目录，使用以该目录保存的FileTokenStore
+	Logger                  Logger     `json:"-" yaml:"-"`                                                   // 日志，为空时使用标准库log输出
+	LogLevel                string     `json:"log_level" yaml:"log_level"`                                   // 未设置Logger时默认日志的级别，debug、info、warn、error，默认为info
 }
 
 // APIClient 的所有变量
 type APIClient struct {
-	client                  *http.Client // HTTP client used to communicate with the API.
-	BaseURL                 *url.URL
+	client                  *http.Client        // HTTP client used to communicate with the API.
+	timeout                 time.Duration       // 每次调用接口的超时时间，0为不限制
+	BaseURL                 *url.URL            // 微信公众平台接口地址，接口的相对路径都基于这个地址，以/结尾
+	MchBaseURL              *url.URL            // 微信商户平台接口地址，以/结尾
+	OpenBaseURL             *url.URL            // 微信网页授权页面地址，以/结尾
+	failoverHosts           []string            // BaseURL的备用地址
+	hostPool                *hostPool           // BaseURL及其备用地址的健康状况
 	AppID                   string              // 公众号AppID
+	OriginalID              string              // 公众号原始ID
 	AppSecret               string              // 公众号AppSecret
 	MchID                   string              // 商户ID
 	MchSecret               string              // 商户Secret
 	MemberCardID            string              // 会员卡ID
 	accessTokenCachePolicy  string              // 公众号AccessToken缓存策略
 	accessTokenCacheAddress string              // 公众号AccessToken缓存地址
-	autonomicAccessToken    string              // 当accessToken缓存策略为自治时，动态的维护这个accessToken，其他策略下，该数值为空
+	accessTokenCacheSecret  string              // 访问中控服务器的共享密钥
+	stableAccessToken       bool                // 是否使用稳定版接口获取AccessToken
+	tokenStore              TokenStore          // 公众号AccessToken的存储，所有缓存策略下都从这里读取AccessToken
+	logger                  Logger              // 日志，输出前会隐藏凭证和个人信息
+	interceptors            []Interceptor       // 每次发送请求前后执行的Interceptor
+	retryPolicy             RetryPolicy         // 临时故障的重试策略
+	buckets                 []*tokenBucket      // 按接口分组的限流
+	quota                   *quotaTracker       // 接口每日调用次数的统计
+	breaker                 *circuitBreaker     // 按接口熔断，为nil时不熔断
+	debugCapture            int                 // 调试日志最多输出的返回数据字节数
+	refreshGroup            flightGroup         // 合并并发的AccessToken刷新请求
+	forceLimiter            ForceRefreshLimiter // 强制刷新AccessToken的频率限制
+	retiredMu               sync.Mutex          // 保护retiredToken
+	retiredToken            string              // 最近一次刷新时被替换掉的AccessToken
+	timerMu                 sync.Mutex          // 保护timer和closed
+	timer                   *time.Timer         // 自治维护AccessToken的timer
+	closed                  bool                // 是否已调用Close
+	sharedRefresh           bool                // 由Registry统一维护AccessToken，不启动自己的timer
 	common                  service             // Reuse a single struct instead of allocating one for each service on the heap.
 	User                    *UserService        // 与微信公众平台服务的用户管理相关接口
 	Card                    *CardService        // 与微信公众平台服务的微信卡券相关接口
 	Pay                     *PayService         // 与微信商户平台服务的微信支付相关接口
 	AccessToken             *AccessTokenService // 与微信公众平台服务的AccessToken相关接口
 	OAuth                   *OAuthService       // 与微信公众平台服务的网页授权相关接口
+	Quota                   *QuotaService       // 与微信公众平台服务的接口调用次数相关接口
 }
 
 type service struct {
 	wechat *APIClient
 }
 
-// New 生成一个wechat实例
-func New(config *APIConfig) *APIClient {
+// New 生成一个wechat实例，配置无效时panic，建议使用返回error的NewClient
+func New(config *APIConfig, opts ...Option) *APIClient {
+	w, err := NewClient(config, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return w
+}
+
+// NewClient 校验配置并生成一个wechat实例，可以通过opts自定义http.Client、代理、超时和接口地址
+// 配置或opts有问题时，返回包含所有问题的*ConfigError
+func NewClient(config *APIConfig, opts ...Option) (*APIClient, error) {
+	if config == nil {
+		return nil, &ConfigError{Problems: []string{problemNilConfig}}
+	}
+	problems := config.problems()
+
+	baseURL, _ := url.Parse(defaultBaseURL)
+	mchBaseURL, _ := url.Parse(defaultMchBaseURL)
+	openBaseURL, _ := url.Parse(defaultOpenBaseURL)
 	w := &APIClient{
-		client:                 http.DefaultClient,
-		AppID:                  config.AppID,
-		AppSecret:              config.AppSecret,
-		MchID:                  config.MchID,
-		MchSecret:              config.MchSecret,
-		MemberCardID:           config.MemberCardID,
-		accessTokenCachePolicy: config.AccessTokenCachePolicy,
+		client:                  &http.Client{},
+		timeout:                 defaultTimeout,
+		BaseURL:                 baseURL,
+		MchBaseURL:              mchBaseURL,
+		OpenBaseURL:             openBaseURL,
+		AppID:                   config.AppID,
+		OriginalID:              config.OriginalID,
+		AppSecret:               config.AppSecret,
+		MchID:                   config.MchID,
+		MchSecret:               config.MchSecret,
+		MemberCardID:            config.MemberCardID,
+		accessTokenCachePolicy:  config.AccessTokenCachePolicy,
+		accessTokenCacheAddress: config.AccessTokenCacheAddress,
+		accessTokenCacheSecret:  config.AccessTokenCacheSecret,
+		stableAccessToken:       config.AccessTokenStable,
+		tokenStore:              config.TokenStore,
+		logger:                  config.Logger,
+		retryPolicy:             DefaultRetryPolicy(),
+		quota:                   newQuotaTracker(defaultDailyQuotas),
+		breaker:                 newCircuitBreaker(DefaultCircuitBreakerConfig()),
+		debugCapture:            defaultDebugCapture,
+	}
+	if w.logger == nil {
+		w.logger = newStdLogger(config.LogLevel)
+	}
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if w.tokenStore == nil && len(config.TokenStoreDir) > 0 {
+		store, err := NewFileTokenStore(config.TokenStoreDir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("无法使用TokenStoreDir %s", err.Error()))
+		}
+		w.tokenStore = store
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
 	}
 
+	if w.tokenStore == nil {
+		w.tokenStore = NewMemoryTokenStore()
+	}
+	if w.failoverHosts == nil && w.BaseURL.String() == defaultBaseURL {
+		w.failoverHosts = defaultFailoverHosts
+	}
+	w.hostPool = newHostPool(w.BaseURL.Host, w.failoverHosts)
+	w.interceptors = append(w.interceptors, w.breakerInterceptor, w.limitInterceptor)
+
 	w.common.wechat = w
 
 	w.User = (*UserService)(&w.common)
@@ -78,41 +172,142 @@ func New(config *APIConfig) *APIClient {
 	w.Pay = (*PayService)(&w.common)
 	w.AccessToken = (*AccessTokenService)(&w.common)
 	w.OAuth = (*OAuthService)(&w.common)
+	w.Quota = (*QuotaService)(&w.common)
 
 	// 根据AccessToken缓存机制的设置进行初始化
 	switch w.accessTokenCachePolicy {
 	case CachePolicyNone:
-		log.Print("不维护AccessToken")
+		w.logger.Info("不维护AccessToken，只从TokenStore读取", "appid", w.AppID)
 	case CachePolicyAutonomy:
-		// 开始自治维护AccessToken
-		w.startTimer()
-		log.Print("自治维护AccessToken")
-	case CachePolicyHTTP:
-		if strings.HasPrefix(config.AccessTokenCacheAddress, "http://") {
-			// 使用AccessTokenCacheAddress
-			w.accessTokenCacheAddress = config.AccessTokenCacheAddress
-		} else {
-			panic("缓存AccessToken的中控服务器设置无效")
+		// 开始自治维护AccessToken，Registry中的APIClient由Registry统一维护
+		if !w.sharedRefresh {
+			w.startTimer()
 		}
-		log.Print("用中控方式获取AccessToken")
-	default:
-		panic("缓存access_token的机制未正确设置")
+		w.logger.Info("自治维护AccessToken", "appid", w.AppID)
+	case CachePolicyHTTP:
+		w.logger.Info("用中控方式获取AccessToken", "appid", w.AppID, "address", redactURLString(w.accessTokenCacheAddress))
 	}
-	return w
+	return w, nil
 }
 
 // GetAccessToken 获取 access token
+// 优先使用TokenStore中未过期的AccessToken，临近过期时按缓存策略刷新:
+// 自治策略从微信服务器获取，http策略从中控服务器获取，不缓存策略只读取TokenStore
+// 使用稳定版AccessToken时，不缓存策略也会按需从微信服务器获取，因为这不会使其他持有者的AccessToken失效
 func (w *APIClient) GetAccessToken() (string, error) {
+	return w.GetAccessTokenContext(context.Background())
+}
+
+// GetAccessTokenContext 与GetAccessToken相同，通过ctx控制请求的超时与取消
+func (w *APIClient) GetAccessTokenContext(ctx context.Context) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	token, expireAt, err := w.tokenStore.Get(w.accessTokenKey())
+	if err == nil && time.Now().Add(durationTokenExpireAhead).Before(expireAt) {
+		return token, nil
+	}
+
 	switch w.accessTokenCachePolicy {
-	case CachePolicyAutonomy:
-		return w.autonomicAccessToken, nil
-	case CachePolicyHTTP:
-		return "暂未实现", errors.New("暂未实现")
+	case CachePolicyNone:
+		if w.stableAccessToken {
+			return w.refreshAccessToken(ctx, "", durationTokenExpireAhead)
+		}
+		if err == nil && time.Now().Before(expireAt) {
+			return token, nil
+		}
+		if err == nil || err == ErrTokenNotFound {
+			return "", errors.New("TokenStore中没有有效的access_token")
+		}
+		return "", err
+	case CachePolicyAutonomy, CachePolicyHTTP:
+		return w.refreshAccessToken(ctx, "", durationTokenExpireAhead)
 	default:
 		return "", errors.New("无法确定的access_token缓存设置")
 	}
 }
 
+// refreshAccessToken 按缓存策略获取新的AccessToken并保存到TokenStore
+// 同一时间对同一个失效token的刷新请求只会执行一次，其他调用者等待并共享结果
+// 刷新不随发起的调用者取消，只受每次调用接口的超时时间限制，调用者取消时只是自己不再等待
+func (w *APIClient) refreshAccessToken(ctx context.Context, invalid string, ahead time.Duration) (string, error) {
+	return w.refreshGroup.do(ctx, invalid, func() (string, error) {
+		ctx := context.WithoutCancel(ctx)
+		if w.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, w.timeout)
+			defer cancel()
+		}
+		return w.doRefreshAccessToken(ctx, invalid, ahead)
+	})
+}
+
+// doRefreshAccessToken 刷新前先获取TokenStore的锁，拿到锁后若发现TokenStore中的AccessToken距离过期还有ahead以上，
+// 且不是invalid这个已知失效的token（说明其他持有者已经刷新过），直接使用
+// 获取失败时，若TokenStore中的AccessToken尚未真正过期，则继续使用
+func (w *APIClient) doRefreshAccessToken(ctx context.Context, invalid string, ahead time.Duration) (string, error) {
+	key := w.accessTokenKey()
+	unlock, err := w.tokenStore.Lock(key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	now := time.Now()
+	token, expireAt, err := w.tokenStore.Get(key)
+	if err != nil && err != ErrTokenNotFound {
+		w.logger.Error("读取TokenStore异常", "error", err)
+	}
+	if err == nil && token != invalid && now.Add(ahead).Before(expireAt) {
+		return token, nil
+	}
+
+	fresh, expiresIn, err := w.fetchAccessToken(ctx, false, invalid)
+	if err != nil {
+		if len(token) > 0 && token != invalid && now.Before(expireAt) {
+			w.logger.Warn("刷新AccessToken失败，继续使用未过期的AccessToken", "error", err)
+			return token, nil
+		}
+		return "", err
+	}
+
+	w.storeAccessToken(key, token, fresh, now.Add(time.Duration(expiresIn)*time.Second))
+	return fresh, nil
+}
+
+// fetchAccessToken 按缓存策略获取新的AccessToken，http策略从中控服务器获取，其他策略从微信服务器获取
+// force为true时，要求强制刷新稳定版AccessToken或中控服务器的AccessToken
+// invalid为已被微信判定失效的AccessToken，http策略下告知中控服务器，使其刷新而不是返回缓存的同一个AccessToken
+func (w *APIClient) fetchAccessToken(ctx context.Context, force bool, invalid string) (string, int64, error) {
+	if w.accessTokenCachePolicy == CachePolicyHTTP {
+		central, err := w.fetchCentralAccessToken(ctx, force, invalid)
+		if err != nil {
+			return "", 0, err
+		}
+		return central.AccessToken, central.ExpiresIn, nil
+	}
+	if w.stableAccessToken {
+		return w.AccessToken.GetStableContext(ctx, force)
+	}
+	return w.AccessToken.GetContext(ctx)
+}
+
+// Close 停止自治维护AccessToken的timer，Close之后APIClient仍可调用接口，但不再主动刷新AccessToken
+func (w *APIClient) Close() error {
+	w.timerMu.Lock()
+	defer w.timerMu.Unlock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	return nil
+}
+
+// accessTokenKey AccessToken在TokenStore中的key
+func (w *APIClient) accessTokenKey() string {
+	return "access_token:" + w.AppID
+}
+
 // NewRequest 创建一个api请求体, 以json发送body参数
 func (w *APIClient) NewRequest(method, urlStr string, body interface{}) (*http.Request, error) {
 	rel, err := url.Parse(urlStr)
@@ -142,71 +337,93 @@ func (w *APIClient) NewRequest(method, urlStr string, body interface{}) (*http.R
 	return req, nil
 }
 
-// Do 执行http请求，并默认用json解析返回数据到结构体v
+// Do 执行http请求，并默认用json流式解析返回数据到结构体v，v是io.Writer时直接把返回数据写入v
+// 返回数据中errcode不为0时，仍会解析到v(io.Writer除外)，同时返回*APIError
+// 微信返回AccessToken失效的错误码时，会刷新AccessToken并用新的AccessToken重新发送请求，最多重试 maxTokenInvalidRetries 次
+// ctx为nil时使用context.Background()，设置了超时时间时，包括重试在内的整个调用受超时限制
+// 遇到系统繁忙、调用太频繁等临时故障时，按WithRetryPolicy设置的策略重试，写接口只在可以安全重试时重试
+// 每次发送请求都会经过WithInterceptors设置的Interceptor
 func (w *APIClient) Do(ctx context.Context, req *http.Request, v interface{}) (*http.Response, error) {
-	if ctx != nil {
-		req = req.WithContext(ctx)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
 	}
+	req = req.WithContext(ctx)
 
-	resp, err := w.client.Do(req)
-	if err != nil {
-		// If we got an error, and the context has been canceled,
-		// the context's error is probably more useful.
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-		}
-
-		// If the error type is *url.Error, sanitize its URL before returning.
-		if e, ok := err.(*url.Error); ok {
-			if url, err := url.Parse(e.URL); err == nil {
-				e.URL = url.String()
-				return nil, e
+	tokenRetries, retries, sends := 0, 0, 0
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		var apiErr *APIError
+		var decodeErr error
+		var sent int
+		handler := chain(func(ctx context.Context, req *http.Request, info *CallInfo) error {
+			start := time.Now()
+			defer func() {
+				info.Duration = time.Since(start)
+			}()
+			var err error
+			if resp, sent, err = w.roundTrip(ctx, req.WithContext(ctx), w.retryPolicy.MaxAttempts-sends); err != nil {
+				return err
 			}
-		}
-
-		return nil, err
-	}
+			info.StatusCode = resp.StatusCode
+			if apiErr, decodeErr, err = w.readResponse(req, resp, v); err != nil {
+				return err
+			}
+			if apiErr != nil {
+				info.Errcode = apiErr.Code
+				return apiErr
+			}
+			return nil
+		}, w.interceptors)
 
-	defer func() {
-		// Drain up to 512 bytes and close the body to let the Transport reuse the connection
-		io.CopyN(ioutil.Discard, resp.Body, 512)
-		resp.Body.Close()
-	}()
+		info := &CallInfo{Endpoint: req.URL.Path, Method: req.Method, Attempt: attempt}
+		err := handler(ctx, req, info)
+		if err == error(apiErr) {
+			err = nil // 微信返回的错误码由apiErr处理，err只保留网络错误和Interceptor返回的错误
+		}
 
-	if v != nil {
-		if w, ok := v.(io.Writer); ok {
-			io.Copy(w, resp.Body)
-		} else {
-			body, err := ioutil.ReadAll(resp.Body)
-			if !strings.Contains(string(body), "ip_list") {
-				log.Printf("url %s body %s", req.URL.Path, string(body))
+		if apiErr != nil && tokenRetries < maxTokenInvalidRetries && isTokenInvalid(apiErr.Code) {
+			if token, ok := w.handleTokenInvalid(ctx, req); ok {
+				if retryReq, err := withAccessToken(req, token); err == nil {
+					tokenRetries++
+					req = retryReq
+					resetResult(v)
+					continue
+				}
 			}
-			err = json.Unmarshal(body, v)
+		}
 
-			if err == io.EOF {
-				err = nil // ignore EOF errors caused by empty response body
-			}
+		// 切换备用地址重新发送的次数也计入重试策略的发送次数，每次调用至少计1次
+		if sent < 1 {
+			sent = 1
 		}
-	}
+		sends += sent
 
-	return resp, err
-}
+		// 返回数据已经写入io.Writer时不能重试
+		_, streamed := v.(io.Writer)
+		written := streamed && resp != nil && apiErr == nil && resp.StatusCode < 400
+		if sends < w.retryPolicy.MaxAttempts && !written && w.retryPolicy.shouldRetry(ctx, req, resp, apiErr, err) {
+			retries++
+			w.logger.Warn("微信接口临时故障，稍后重试", "endpoint", info.Endpoint, "attempt", attempt, "status", info.StatusCode, "errcode", info.Errcode, "error", err)
+			if sleepContext(ctx, w.retryPolicy.backoff(retries)) == nil {
+				if retryReq, err := cloneRequest(req); err == nil {
+					req = retryReq
+					resetResult(v)
+					continue
+				}
+			}
+		}
 
-// startTimer 启动自治维护AccessToken的timer
-func (w *APIClient) startTimer() {
-	if !w.AccessToken.Verify(w.autonomicAccessToken) {
-		accessToken, _, err := w.AccessToken.Get()
 		if err != nil {
-			log.Print(err)
-		} else {
-			w.autonomicAccessToken = accessToken
-			log.Printf("最新获取到的AccessToken是: %s", accessToken)
+			return resp, err
+		}
+		if apiErr != nil {
+			return resp, apiErr
 		}
+		return resp, decodeErr
 	}
-
-	time.AfterFunc(durationRefreshAccessToken, func() {
-		w.startTimer()
-	})
 }
@@ -0,0 +1,39 @@
+package wechat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer 启动模拟微信接口的服务器，测试结束时关闭
+func newTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestClient 创建请求baseURL的APIClient，不缓存AccessToken，也不使用备用地址
+func newTestClient(t *testing.T, baseURL string, opts ...Option) *APIClient {
+	t.Helper()
+	opts = append([]Option{WithBaseURL(baseURL), WithFailoverHosts()}, opts...)
+	w, err := NewClient(&APIConfig{
+		AppID:                  "wx1234567890abcdef",
+		AppSecret:              "0123456789abcdef0123456789abcdef",
+		AccessTokenCachePolicy: CachePolicyNone,
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// dropConnection 读取请求后直接断开连接，模拟请求已发出但没有收到响应
+func dropConnection(rw http.ResponseWriter) {
+	conn, _, err := rw.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}